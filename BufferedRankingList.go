@@ -0,0 +1,215 @@
+package game_rank_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BufferedRankingList 缓冲写入的Redis排行榜，合并高频UpdateScore后批量写入
+// 每个玩家只保留最后一次提交，累计maxPending次更新或每隔interval通过一次管道写入Redis
+// 缓冲中尚未写入的更新在进程崩溃时会丢失，用持久性换取写入吞吐；GetScore、GetRank会读取缓冲，玩家能立即看到自己的更新
+type BufferedRankingList struct {
+	list       *RedisRankingList
+	maxPending int
+	pending    map[string]bufferedScore
+	updates    int  // 上次写入后累计的更新次数
+	closed     bool // Close之后不再接受写入
+	mu         sync.Mutex
+	flushMu    sync.Mutex // 保证各批按顺序写入，旧数据不会覆盖新数据
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// bufferedScore 缓冲中的分数及提交时间
+type bufferedScore struct {
+	score    int64
+	tieBreak int64 // 按提交时间计算的同分排序值，同分先后不受写入时机影响
+}
+
+// NewBufferedRankingList 创建缓冲写入的排行榜
+// maxPending<=0表示不按次数写入，interval<=0表示不定时写入，此时只在Flush和Close时写入
+func NewBufferedRankingList(list *RedisRankingList, maxPending int, interval time.Duration) *BufferedRankingList {
+	b := &BufferedRankingList{
+		list:       list,
+		maxPending: maxPending,
+		pending:    make(map[string]bufferedScore),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if interval > 0 {
+		go b.flushLoop(interval)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// UpdateScore 缓冲玩家积分更新，累计次数达到maxPending时同步写入；Close之后返回ErrClosed
+func (b *BufferedRankingList) UpdateScore(playerID string, score int64) error {
+	score = b.list.opts.orient(b.list.opts.quantize(score))
+	if err := b.list.codec.check(score); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.pending[playerID] = bufferedScore{
+		score:    score,
+		tieBreak: b.list.writeTieBreak(),
+	}
+	b.updates++
+	full := b.maxPending > 0 && b.updates >= b.maxPending
+	b.mu.Unlock()
+
+	if full {
+		return b.Flush()
+	}
+	return nil
+}
+
+// pendingScore 缓冲中尚未写入的玩家内部分数
+func (b *BufferedRankingList) pendingScore(playerID string) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.pending[playerID]
+	return s.score, ok
+}
+
+// GetScore 查询玩家当前分数，缓冲中有尚未写入的更新时返回缓冲中的分数，玩家能立即看到自己的最新分数
+func (b *BufferedRankingList) GetScore(playerID string) (int64, error) {
+	if score, ok := b.pendingScore(playerID); ok {
+		return b.list.opts.orient(score), nil
+	}
+	return b.list.GetScore(playerID)
+}
+
+// Exists 玩家是否在榜，缓冲中有该玩家的更新时视为在榜
+func (b *BufferedRankingList) Exists(playerID string) (bool, error) {
+	if _, ok := b.pendingScore(playerID); ok {
+		return true, nil
+	}
+	return b.list.Exists(playerID)
+}
+
+// GetRank 查询玩家当前名次和分数，缓冲中有尚未写入的更新时按缓冲中的分数计算名次
+// 此时名次按Redis中其他玩家和WithRankMode的规则计算，一次管道往返；密集排名且旧分数更好时多一次ZCOUNT
+// 其他玩家缓冲中的更新不计入
+func (b *BufferedRankingList) GetRank(playerID string) (int, int64, error) {
+	score, ok := b.pendingScore(playerID)
+	if !ok {
+		return b.list.GetRank(playerID)
+	}
+
+	r := b.list
+	var storedCmd *redis.FloatCmd
+	var above func() int64
+	_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		storedCmd = pipe.ZScore(r.ctx, r.key, playerID)
+		above = r.queueRankAbove(pipe, score)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, 0, fmt.Errorf("获取玩家排名失败: %w", err)
+	}
+
+	// Redis中的旧分数比缓冲中的更好时，玩家自己被计入了排在前面的名次数；
+	// 密集排名按不同分数计数，只有没有其他玩家与旧分数相同时才需要扣除
+	rankAbove := above()
+	if stored, err := storedCmd.Result(); err == nil && r.keyScore(stored) > score {
+		shared := false
+		if r.opts.rankMode == DenseRanking {
+			min, max := r.codec.rangeBounds(r.keyScore(stored), r.keyScore(stored))
+			n, err := r.client.ZCount(r.ctx, r.key, min, max).Result()
+			if err != nil {
+				return 0, 0, fmt.Errorf("获取玩家排名失败: %w", err)
+			}
+			shared = n > 1
+		}
+		if !shared {
+			rankAbove--
+		}
+	}
+	return r.opts.outRank(int(rankAbove) + 1), r.opts.orient(score), nil
+}
+
+// GetTopN 获取已写入Redis的前N名，不包含缓冲中尚未写入的更新，需要最新结果时先调用Flush
+func (b *BufferedRankingList) GetTopN(n int) ([]PlayerRank, error) {
+	return b.list.GetTopN(n)
+}
+
+// Flush 立即把缓冲中的更新通过一次管道写入Redis，失败时更新放回缓冲等待下次写入
+func (b *BufferedRankingList) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = make(map[string]bufferedScore)
+	b.updates = 0
+	b.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	r := b.list
+	_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for playerID, s := range batch {
+			r.queueComposite(pipe, playerID, r.codec.encode(s.score, s.tieBreak))
+		}
+		if r.opts.maxSize > 0 {
+			r.queueTrim(pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		// 放回未被新提交覆盖的更新
+		b.mu.Lock()
+		for playerID, s := range batch {
+			if _, ok := b.pending[playerID]; !ok {
+				b.pending[playerID] = s
+			}
+		}
+		b.mu.Unlock()
+		return fmt.Errorf("批量写入失败: %w", tieBreakErr(err))
+	}
+	return nil
+}
+
+// Close 停止定时写入并写入缓冲中剩余的更新，之后的UpdateScore返回ErrClosed
+func (b *BufferedRankingList) Close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+		close(b.stop)
+	})
+	<-b.done
+	return b.Flush()
+}
+
+// flushLoop 定时写入，出错时更新留在缓冲中等待下次写入
+func (b *BufferedRankingList) flushLoop(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = b.Flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package game_rank_test
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestBufferedGetRank 缓冲中的更新未写入时GetRank的名次与写入后Redis排行榜的名次一致，两种名次规则都成立
+func TestBufferedGetRank(t *testing.T) {
+	for _, mode := range []RankMode{StandardRanking, DenseRanking} {
+		// me在Redis中的旧分数分别与他人相同、唯一，缓冲中的新分数分别更低、更高
+		for _, tc := range []struct{ stored, pending int64 }{{50, 20}, {40, 20}, {40, 60}, {30, 30}} {
+			r, _ := newTestRedis(t, WithRankMode(mode))
+			if err := r.UpdateScores(map[string]int64{"a": 50, "b": 50, "c": 30, "me": tc.stored}); err != nil {
+				t.Fatal(err)
+			}
+			b := NewBufferedRankingList(r, 0, 0)
+			if err := b.UpdateScore("me", tc.pending); err != nil {
+				t.Fatal(err)
+			}
+
+			rank, score, err := b.GetRank("me")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			wantRank, wantScore, err := r.GetRank("me")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rank != wantRank || score != wantScore {
+				t.Errorf("mode=%v stored=%d pending=%d: buffered GetRank = %d, %d, want %d, %d", mode, tc.stored, tc.pending, rank, score, wantRank, wantScore)
+			}
+		}
+	}
+}
+
+// TestBufferedUpdateAfterClose Close之后的写入返回ErrClosed，不会静默留在缓冲中
+func TestBufferedUpdateAfterClose(t *testing.T) {
+	r, _ := newTestRedis(t)
+	b := NewBufferedRankingList(r, 0, 0)
+	if err := b.UpdateScore("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateScore("b", 20); !errors.Is(err, ErrClosed) {
+		t.Errorf("UpdateScore after Close error = %v, want ErrClosed", err)
+	}
+	if score, err := r.GetScore("a"); err != nil || score != 10 {
+		t.Errorf("GetScore(a) = %d, %v, want the update flushed by Close", score, err)
+	}
+	if ok, err := b.Exists("b"); err != nil || ok {
+		t.Errorf("Exists(b) = %v, %v, want false", ok, err)
+	}
+}
@@ -0,0 +1,164 @@
+package game_rank_test
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Redis排行榜的复合分数 = 真实分数 << 同分排序位数 + 低位
+// 同分排序值是更新时间距tieBreakEpoch的秒数，默认低位 = 同分排序位上限 - 同分排序值，越早更新低位越大、排名越靠前；
+// WithTieBreak(LatestFirst)时低位 = 同分排序值，越晚更新排名越靠前；
+// WithSecondaryTieBreak时同分排序值改为次要排序值加上低位范围的一半，低位 = 同分排序值，不再记录时间
+// Redis的分数是float64，只能精确表示2^53以内的整数，因此真实分数和时间共用53位，
+// 默认真实分数占25位（含符号位），时间占28位（按秒约8.5年，至2032年7月），可用WithScoreBits调整
+// 超过2032-07-03 21:24:15 UTC后同分排序值恒为上限，同分玩家不再按时间区分，只按成员ID排序
+//
+// 存储格式与早期版本不兼容：早期版本的复合分数为 真实分数 << 40 + 毫秒时间戳，没有版本标记，
+// 用本版本读取旧键得到的分数和名次都是错误的；升级前需清空旧键，再用UpdateScores重新写入所有玩家的分数
+const (
+	compositeBits       = 53
+	defaultScoreBits    = 25
+	defaultTieBreakBits = compositeBits - defaultScoreBits
+
+	// MaxScore 默认配置下Redis排行榜支持的最大真实分数
+	MaxScore = 1<<(defaultScoreBits-1) - 1
+	// MinScore 默认配置下Redis排行榜支持的最小真实分数
+	MinScore = -(1 << (defaultScoreBits - 1))
+)
+
+// tieBreakEpoch 同分排序时间的起点，早于起点按起点计算，超出时间位可表示的范围按上限计算
+var tieBreakEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// compositeCodec 按位数配置编解码复合分数
+type compositeCodec struct {
+	tieBreakBits uint
+	tieBreakMax  int64
+	minScore     int64
+	maxScore     int64
+	latestFirst  bool // 同分时更新越晚越靠前
+	secondary    bool // 低位存放次要排序值而不是更新时间
+}
+
+// newCompositeCodec 真实分数占scoreBits位（含符号位），其余位用于按tieBreak方向同分排序，secondary时改为存放次要排序值
+func newCompositeCodec(scoreBits int, tieBreak TieBreakOrder, secondary bool) (compositeCodec, error) {
+	if scoreBits < 2 || scoreBits >= compositeBits {
+		return compositeCodec{}, fmt.Errorf("分数位数%d无效，需在[2, %d]内", scoreBits, compositeBits-1)
+	}
+
+	tieBreakBits := uint(compositeBits - scoreBits)
+	return compositeCodec{
+		tieBreakBits: tieBreakBits,
+		tieBreakMax:  1<<tieBreakBits - 1,
+		minScore:     -(1 << (scoreBits - 1)),
+		maxScore:     1<<(scoreBits-1) - 1,
+		latestFirst:  tieBreak == LatestFirst,
+		secondary:    secondary,
+	}, nil
+}
+
+// unit 真实分数每加1复合分数增加的值
+func (c compositeCodec) unit() int64 {
+	return 1 << c.tieBreakBits
+}
+
+// tieBreakOf 把更新时间换算为同分排序值
+func (c compositeCodec) tieBreakOf(t time.Time) int64 {
+	sec := int64(t.Sub(tieBreakEpoch) / time.Second)
+	if sec < 0 {
+		return 0
+	}
+	if sec > c.tieBreakMax {
+		return c.tieBreakMax
+	}
+	return sec
+}
+
+// tieBreakTime 同分排序值对应的更新时间（精确到秒）
+func (c compositeCodec) tieBreakTime(tieBreak int64) time.Time {
+	return tieBreakEpoch.Add(time.Duration(tieBreak) * time.Second)
+}
+
+// secondaryOf 把次要排序值换算为同分排序值，超出低位可表示的范围时返回ErrScoreOutOfRange
+func (c compositeCodec) secondaryOf(tiebreak int64) (int64, error) {
+	half := (c.tieBreakMax + 1) / 2
+	if tiebreak < -half || tiebreak >= half {
+		return 0, fmt.Errorf("%w: 次要排序值%d不在[%d, %d]内", ErrScoreOutOfRange, tiebreak, -half, half-1)
+	}
+	return tiebreak + half, nil
+}
+
+// secondaryValue 同分排序值对应的次要排序值
+func (c compositeCodec) secondaryValue(tieBreak int64) int64 {
+	return tieBreak - (c.tieBreakMax+1)/2
+}
+
+// encode 由真实分数和同分排序值生成复合分数
+func (c compositeCodec) encode(score int64, tieBreak int64) float64 {
+	return float64(score<<c.tieBreakBits + c.low(tieBreak))
+}
+
+// low 同分排序值对应的低位，低位换算回同分排序值也用同一个函数
+func (c compositeCodec) low(tieBreak int64) int64 {
+	if c.latestFirst || c.secondary {
+		return tieBreak
+	}
+	return c.tieBreakMax - tieBreak
+}
+
+// nth 同分玩家中排第seq位（从0开始）的玩家使用的同分排序值
+func (c compositeCodec) nth(seq int64) int64 {
+	return c.low(c.tieBreakMax - seq)
+}
+
+// split 从复合分数中拆出真实分数和同分排序值，负分按算术右移向下取整同样可以还原
+func (c compositeCodec) split(composite float64) (score int64, tieBreak int64) {
+	v := int64(composite)
+	score = v >> c.tieBreakBits
+	return score, c.low(v - score<<c.tieBreakBits)
+}
+
+// floor 真实分数为score的最小复合分数
+func (c compositeCodec) floor(score int64) float64 {
+	return float64(score << c.tieBreakBits)
+}
+
+// check 检查真实分数是否在复合分数可精确表示的范围内
+func (c compositeCodec) check(score int64) error {
+	if score > c.maxScore || score < c.minScore {
+		return fmt.Errorf("%w: %d不在[%d, %d]内", ErrScoreOutOfRange, score, c.minScore, c.maxScore)
+	}
+	return nil
+}
+
+// aboveBound 真实分数严格高于score的复合分数下界，用作ZCOUNT的min
+// score超出可表示范围时直接返回无穷，避免移位溢出
+func (c compositeCodec) aboveBound(score int64) string {
+	if score >= c.maxScore {
+		return "+inf"
+	}
+	if score < c.minScore {
+		return "-inf"
+	}
+	return strconv.FormatFloat(c.floor(score+1), 'f', -1, 64)
+}
+
+// rangeBounds 把真实分数闭区间换算为ZCOUNT/ZRANGEBYSCORE的复合分数边界
+// 超出可表示范围的部分不可能有玩家，先截断避免移位溢出；整个区间都在范围外时得到空区间
+func (c compositeCodec) rangeBounds(minScore, maxScore int64) (min, max string) {
+	if minScore < c.minScore {
+		minScore = c.minScore
+	}
+	if minScore > c.maxScore {
+		minScore = c.maxScore + 1
+	}
+	if maxScore > c.maxScore {
+		maxScore = c.maxScore
+	}
+	if maxScore < c.minScore {
+		maxScore = c.minScore - 1
+	}
+	min = strconv.FormatFloat(c.floor(minScore), 'f', -1, 64)
+	max = "(" + strconv.FormatFloat(c.floor(maxScore+1), 'f', -1, 64)
+	return min, max
+}
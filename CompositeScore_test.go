@@ -0,0 +1,170 @@
+package game_rank_test
+
+import (
+	"testing"
+	"time"
+)
+
+// defaultCodec 默认配置的复合分数编解码
+func defaultCodec(t *testing.T) compositeCodec {
+	t.Helper()
+	c, err := newCompositeCodec(defaultScoreBits, EarliestFirst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestCompositeLargeScores 千万级分数仍能精确区分相差1分的玩家，同分按时间先后排序
+func TestCompositeLargeScores(t *testing.T) {
+	c := defaultCodec(t)
+	t0 := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Second)
+
+	lowerEarly := c.encode(10000000, c.tieBreakOf(t0))
+	lowerLate := c.encode(10000000, c.tieBreakOf(t1))
+	higherLate := c.encode(10000001, c.tieBreakOf(t1))
+	if !(higherLate > lowerEarly && lowerEarly > lowerLate) {
+		t.Fatalf("composites out of order: 10000001@t1=%v 10000000@t0=%v 10000000@t1=%v", higherLate, lowerEarly, lowerLate)
+	}
+	for _, tc := range []struct {
+		composite float64
+		score     int64
+		at        time.Time
+	}{{lowerEarly, 10000000, t0}, {lowerLate, 10000000, t1}, {higherLate, 10000001, t1}} {
+		score, tieBreak := c.split(tc.composite)
+		if score != tc.score || !c.tieBreakTime(tieBreak).Equal(tc.at) {
+			t.Errorf("split(%v) = %d, %v, want %d, %v", tc.composite, score, c.tieBreakTime(tieBreak), tc.score, tc.at)
+		}
+	}
+
+	// early先以10000000上榜，late一秒后同分上榜，high再过一秒以10000001上榜
+	clock := newTestClock()
+	r, _ := newTestRedis(t, WithClock(clock.now))
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"early", 10000000}, {"late", 10000000}, {"high", 10000001}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	top, err := r.GetTopN(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PlayerRank{
+		{PlayerID: "high", Score: 10000001, Rank: 1},
+		{PlayerID: "early", Score: 10000000, Rank: 2},
+		{PlayerID: "late", Score: 10000000, Rank: 2},
+	}
+	if len(top) != len(want) {
+		t.Fatalf("GetTopN(3) = %+v", top)
+	}
+	for i, w := range want {
+		if top[i].PlayerID != w.PlayerID || top[i].Score != w.Score || top[i].Rank != w.Rank {
+			t.Errorf("top[%d] = %+v, want %+v", i, top[i], w)
+		}
+		if score, err := r.GetScore(w.PlayerID); err != nil || score != w.Score {
+			t.Errorf("GetScore(%s) = %d, %v, want %d", w.PlayerID, score, err, w.Score)
+		}
+	}
+}
+
+// TestTieBreakTimeLimit 默认配置下同分排序时间到2032年7月用尽，此后的写入同分时不再按时间区分
+func TestTieBreakTimeLimit(t *testing.T) {
+	c := defaultCodec(t)
+	last := c.tieBreakTime(c.tieBreakMax)
+	if last.Year() != 2032 || last.Month() != time.July {
+		t.Fatalf("tie-break range ends at %v, want July 2032", last)
+	}
+	if c.tieBreakOf(last.Add(time.Hour)) != c.tieBreakOf(last.Add(24*time.Hour)) {
+		t.Error("tie-break values past the limit should clamp to the same value")
+	}
+	if c.tieBreakOf(tieBreakEpoch.Add(-time.Hour)) != 0 {
+		t.Error("tie-break values before the epoch should clamp to 0")
+	}
+}
+
+// TestCompositeNegativeScores 负分和0分的复合分数可还原，且与正分的先后顺序正确
+func TestCompositeNegativeScores(t *testing.T) {
+	scores := []int64{-100, -1, 0, 1}
+	at := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, tieBreak := range []TieBreakOrder{EarliestFirst, LatestFirst} {
+		c, err := newCompositeCodec(defaultScoreBits, tieBreak, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev := c.encode(MinScore, c.tieBreakMax)
+		for _, score := range scores {
+			for _, tb := range []int64{0, c.tieBreakOf(at), c.tieBreakMax} {
+				composite := c.encode(score, tb)
+				if gotScore, gotTieBreak := c.split(composite); gotScore != score || gotTieBreak != tb {
+					t.Errorf("tieBreak=%v split(encode(%d, %d)) = %d, %d", tieBreak, score, tb, gotScore, gotTieBreak)
+				}
+				if composite < c.floor(score) || composite >= c.floor(score+1) {
+					t.Errorf("tieBreak=%v encode(%d, %d) = %v outside [floor(%d), floor(%d))", tieBreak, score, tb, composite, score, score+1)
+				}
+			}
+			if lowest := c.floor(score); lowest <= prev {
+				t.Errorf("tieBreak=%v floor(%d) = %v not above the previous score", tieBreak, score, lowest)
+			}
+			prev = c.encode(score, c.tieBreakMax)
+		}
+	}
+
+	r, _ := newTestRedis(t)
+	for _, score := range scores {
+		composite := r.codec.encode(score, r.codec.tieBreakOf(at))
+		if got := r.GetRealScore(composite); got != score {
+			t.Errorf("GetRealScore(encode(%d)) = %d", score, got)
+		}
+	}
+}
+
+// TestNegativeScoreRanks 负分、0分和正分在升序和降序榜单上的名次，两种排行榜一致
+func TestNegativeScoreRanks(t *testing.T) {
+	players := map[string]int64{"m100": -100, "m1": -1, "zero": 0, "p1": 1}
+	cases := []struct {
+		order Order
+		want  []string
+	}{
+		{Descending, []string{"p1", "zero", "m1", "m100"}},
+		{Ascending, []string{"m100", "m1", "zero", "p1"}},
+	}
+	for _, tc := range cases {
+		clock := newTestClock()
+		rds, _ := newTestRedis(t, WithClock(clock.now), WithOrder(tc.order))
+		for _, r := range []Ranker{NewRankingSystem(WithClock(clock.now), WithOrder(tc.order)), rds} {
+			for id, score := range players {
+				if err := r.UpdateScore(id, score); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, id := range tc.want {
+				rank, score, err := r.GetRank(id)
+				if err != nil || rank != i+1 || score != players[id] {
+					t.Errorf("%T order=%v GetRank(%s) = %d, %d, %v, want %d, %d", r, tc.order, id, rank, score, err, i+1, players[id])
+				}
+			}
+			if got := topIDs(t, r, 4); !equalIDs(got, tc.want) {
+				t.Errorf("%T order=%v top = %v, want %v", r, tc.order, got, tc.want)
+			}
+		}
+	}
+}
+
+// equalIDs 两个玩家ID列表是否相同
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
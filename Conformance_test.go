@@ -0,0 +1,228 @@
+package game_rank_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// testClock 可手动推进的时钟
+type testClock struct {
+	t time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{t: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) now() time.Time { return c.t }
+
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestRedis 创建连接到miniredis的Redis排行榜，测试结束时关闭
+func newTestRedis(t testing.TB, opts ...Option) (*RedisRankingList, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	r, err := NewRedisRankingWithClient(client, "rank:test", opts...)
+	if err != nil {
+		t.Fatalf("NewRedisRankingWithClient: %v", err)
+	}
+	return r, mr
+}
+
+// conformanceStep 脚本中的一步，advance为执行前推进的时间
+type conformanceStep struct {
+	advance time.Duration
+	player  string
+	score   int64
+	remove  bool
+}
+
+// conformanceScript 覆盖同分先后、同一秒内同分、重复提交同分、降分、移除和负分
+var conformanceScript = []conformanceStep{
+	{advance: time.Second, player: "alice", score: 100},
+	{advance: time.Second, player: "bob", score: 100},
+	{advance: 0, player: "carol", score: 100}, // 与bob同一秒，按ID排序
+	{advance: time.Second, player: "dave", score: 250},
+	{advance: 300 * time.Millisecond, player: "erin", score: 250},
+	{advance: time.Minute, player: "alice", score: 100}, // 重复提交同分
+	{advance: time.Second, player: "frank", score: -5},
+	{advance: time.Second, player: "grace", score: 0},
+	{advance: time.Second, player: "heidi", score: 250},
+	{advance: time.Second, player: "bob", score: 40}, // 降分
+	{advance: time.Second, player: "ivan", score: 100},
+	{advance: time.Second, player: "judy", score: 7},
+	{advance: time.Second, player: "grace", remove: true},
+	{advance: time.Hour, player: "kim", score: 250},
+	{advance: time.Second, player: "leo", score: -5},
+}
+
+// conformanceRanks 写出前N名、逐个玩家的名次和分页结果，UpdateTime只有内存排行榜填写，比较前清零
+func conformanceRanks(t *testing.T, r Ranker) string {
+	t.Helper()
+	strip := func(prs []PlayerRank) []PlayerRank {
+		for i := range prs {
+			prs[i].UpdateTime = time.Time{}
+			prs[i].Meta = nil
+		}
+		return prs
+	}
+
+	out := map[string]interface{}{}
+	top, err := r.GetTopN(100)
+	if err != nil {
+		t.Fatalf("GetTopN: %v", err)
+	}
+	out["top"] = strip(top)
+
+	ranks := map[string][2]int64{}
+	for _, pr := range top {
+		rank, score, err := r.GetRank(pr.PlayerID)
+		if err != nil {
+			t.Fatalf("GetRank(%s): %v", pr.PlayerID, err)
+		}
+		ranks[pr.PlayerID] = [2]int64{int64(rank), score}
+	}
+	out["ranks"] = ranks
+
+	for offset := 0; offset < len(top); offset += 3 {
+		page, err := r.GetRankPage(offset, 3)
+		if err != nil {
+			t.Fatalf("GetRankPage(%d): %v", offset, err)
+		}
+		out[fmt.Sprintf("page%d", offset)] = strip(page)
+	}
+	for _, id := range []string{"alice", "erin", "leo"} {
+		window, err := r.GetPlayerRankRange(id, 4)
+		if err != nil {
+			t.Fatalf("GetPlayerRankRange(%s): %v", id, err)
+		}
+		out["window:"+id] = strip(window)
+	}
+	total, err := r.GetTotalPlayers()
+	if err != nil {
+		t.Fatalf("GetTotalPlayers: %v", err)
+	}
+	out["total"] = total
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// runConformanceScript 在排行榜上执行脚本
+func runConformanceScript(t *testing.T, r Ranker, clock *testClock) {
+	t.Helper()
+	for i, step := range conformanceScript {
+		clock.advance(step.advance)
+		if step.remove {
+			if _, err := r.RemovePlayer(step.player); err != nil {
+				t.Fatalf("step %d RemovePlayer(%s): %v", i, step.player, err)
+			}
+			continue
+		}
+		if err := r.UpdateScore(step.player, step.score); err != nil {
+			t.Fatalf("step %d UpdateScore(%s, %d): %v", i, step.player, step.score, err)
+		}
+	}
+}
+
+// TestBackendConformance 同样的UpdateScore序列和时钟下，两种排行榜的查询结果逐字节一致
+// Redis排行榜每次写入都刷新同分排序时间，内存排行榜需配置RefreshOnResubmit才与之一致，见WithTiePolicy
+func TestBackendConformance(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"latest first", []Option{WithTieBreak(LatestFirst)}},
+		{"ascending", []Option{WithOrder(Ascending)}},
+		{"dense", []Option{WithRankMode(DenseRanking)}},
+		{"zero based", []Option{WithZeroBasedRanks()}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			memClock, redisClock := newTestClock(), newTestClock()
+			mem := NewRankingSystem(append([]Option{WithClock(memClock.now), WithTiePolicy(RefreshOnResubmit)}, tc.opts...)...)
+			rds, _ := newTestRedis(t, append([]Option{WithClock(redisClock.now)}, tc.opts...)...)
+
+			runConformanceScript(t, mem, memClock)
+			runConformanceScript(t, rds, redisClock)
+
+			want, got := conformanceRanks(t, mem), conformanceRanks(t, rds)
+			if want != got {
+				t.Fatalf("backends differ\n%s", firstDiff(want, got))
+			}
+		})
+	}
+}
+
+// firstDiff 两段文本第一处不同的行及其上文
+func firstDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		if i < len(w) && i < len(g) && w[i] == g[i] {
+			continue
+		}
+		from := max(0, i-12)
+		var b strings.Builder
+		for j := from; j < i && j < len(w); j++ {
+			fmt.Fprintf(&b, "  %s\n", w[j])
+		}
+		if i < len(w) {
+			fmt.Fprintf(&b, "- %s\n", w[i])
+		}
+		if i < len(g) {
+			fmt.Fprintf(&b, "+ %s\n", g[i])
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// TestSameSecondTieOrder 同分玩家在同一秒内先后更新时，两种排行榜都按玩家ID降序排列，与更新的先后无关
+func TestSameSecondTieOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"earliest first", nil},
+		{"latest first", []Option{WithTieBreak(LatestFirst)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			memClock, rdsClock := newTestClock(), newTestClock()
+			rds, _ := newTestRedis(t, append([]Option{WithClock(rdsClock.now)}, tc.opts...)...)
+			boards := []struct {
+				clock *testClock
+				r     Ranker
+			}{
+				{memClock, NewRankingSystem(append([]Option{WithClock(memClock.now)}, tc.opts...)...)},
+				{rdsClock, rds},
+			}
+			for _, board := range boards {
+				// a比b早300毫秒，但两者在同一秒内
+				board.clock.advance(200 * time.Millisecond)
+				if err := board.r.UpdateScore("a", 10); err != nil {
+					t.Fatal(err)
+				}
+				board.clock.advance(300 * time.Millisecond)
+				if err := board.r.UpdateScore("b", 10); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := topIDs(t, board.r, 2), []string{"b", "a"}; !equalIDs(got, want) {
+					t.Fatalf("%T order = %v, want %v", board.r, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package game_rank_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrNotEnoughPlayers 排行榜人数不足以满足查询
+var ErrNotEnoughPlayers = errors.New("not enough players")
+
+// ErrScoreOutOfRange 分数超出Redis复合分数可精确表示的范围，默认范围见MaxScore和MinScore，可用WithScoreBits调整
+var ErrScoreOutOfRange = errors.New("score out of range")
+
+// ErrPlayerNotFound 玩家不在排行榜中
+var ErrPlayerNotFound = errors.New("player not found")
+
+// ErrInvalidN 查询数量N必须大于0
+var ErrInvalidN = errors.New("n must be greater than 0")
+
+// ErrInvalidRange 分页的offset、limit，名次区间或分桶边界等查询参数无效
+var ErrInvalidRange = errors.New("invalid range")
+
+// ErrBaselineNotFound 指定名称的排名基线不存在
+var ErrBaselineNotFound = errors.New("baseline not found")
+
+// ErrSnapshotNotFound 指定的名次快照不存在或已删除
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// ErrTieBreakExhausted WithCollisionAvoidance避让复合分数冲突时，同一真实分数内已没有可用的同分排序值
+var ErrTieBreakExhausted = errors.New("tie-break exhausted")
+
+// tieBreakExhaustedPrefix zaddNoCollision返回的错误前缀
+const tieBreakExhaustedPrefix = "TIEBREAK"
+
+// tieBreakErr 把zaddNoCollision的错误转换为ErrTieBreakExhausted，其他错误原样返回
+func tieBreakErr(err error) error {
+	if err != nil && strings.HasPrefix(err.Error(), tieBreakExhaustedPrefix) {
+		return fmt.Errorf("%w: %v", ErrTieBreakExhausted, err)
+	}
+	return err
+}
+
+// ErrClosed 缓冲排行榜已关闭，不再接受写入
+var ErrClosed = errors.New("ranking list closed")
+
+// ErrArchiveExists 归档目标已存在
+var ErrArchiveExists = errors.New("archive already exists")
+
+// isTransient Redis命令的错误是否为断线、超时、服务端加载中等暂时性故障，而不是玩家不存在、参数无效等逻辑错误
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	s := err.Error()
+	for _, transient := range []string{"connection pool timeout", "max number of clients reached", "LOADING ", "READONLY ", "CLUSTERDOWN ", "TRYAGAIN "} {
+		if strings.Contains(s, transient) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package game_rank_test
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestSentinelErrors 两种排行榜对不在榜的玩家和无效参数返回可用errors.Is判断的错误
+func TestSentinelErrors(t *testing.T) {
+	type ranker interface {
+		Ranker
+		GetPlayerRankRangeStrict(playerID string, n int) ([]PlayerRank, error)
+		GetRankRange(startRank, endRank int) ([]PlayerRank, error)
+		RankBandScores(startRank, endRank int) (int64, int64, error)
+		RankRangeByPercentile(lowPct, highPct float64) ([]PlayerRank, error)
+		CountInScoreRange(minScore, maxScore int64) (int64, error)
+		GetPlayersInScoreRange(minScore, maxScore int64, limit int) ([]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []ranker{NewRankingSystem(), rds} {
+		if err := r.UpdateScore("a", 10); err != nil {
+			t.Fatal(err)
+		}
+
+		notFound := map[string]error{}
+		_, _, notFound["GetRank"] = r.GetRank("ghost")
+		_, notFound["GetScore"] = r.GetScore("ghost")
+		_, notFound["GetPlayerRankRange"] = r.GetPlayerRankRange("ghost", 3)
+		for op, err := range notFound {
+			if !errors.Is(err, ErrPlayerNotFound) {
+				t.Errorf("%T %s error = %v, want ErrPlayerNotFound", r, op, err)
+			}
+		}
+
+		invalidN := map[string]error{}
+		_, invalidN["GetTopN"] = r.GetTopN(0)
+		_, invalidN["GetPlayerRankRangeStrict"] = r.GetPlayerRankRangeStrict("a", 0)
+		for op, err := range invalidN {
+			if !errors.Is(err, ErrInvalidN) {
+				t.Errorf("%T %s error = %v, want ErrInvalidN", r, op, err)
+			}
+		}
+
+		invalidRange := map[string]error{}
+		_, invalidRange["GetRankPage offset"] = r.GetRankPage(-1, 10)
+		_, invalidRange["GetRankPage limit"] = r.GetRankPage(0, 0)
+		_, invalidRange["GetRankRange start"] = r.GetRankRange(0, 5)
+		_, invalidRange["GetRankRange end"] = r.GetRankRange(5, 4)
+		_, _, invalidRange["RankBandScores start"] = r.RankBandScores(0, 5)
+		_, _, invalidRange["RankBandScores end"] = r.RankBandScores(5, 4)
+		_, invalidRange["RankRangeByPercentile low"] = r.RankRangeByPercentile(-0.1, 0.5)
+		_, invalidRange["RankRangeByPercentile high"] = r.RankRangeByPercentile(0.5, 0.5)
+		_, invalidRange["CountInScoreRange"] = r.CountInScoreRange(20, 10)
+		_, invalidRange["GetPlayersInScoreRange"] = r.GetPlayersInScoreRange(20, 10, 5)
+		for op, err := range invalidRange {
+			if !errors.Is(err, ErrInvalidRange) {
+				t.Errorf("%T %s error = %v, want ErrInvalidRange", r, op, err)
+			}
+		}
+
+		if _, _, err := r.RankBandScores(2, 5); !errors.Is(err, ErrNotEnoughPlayers) {
+			t.Errorf("%T RankBandScores past the last rank error = %v, want ErrNotEnoughPlayers", r, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package game_rank_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// PlayerRecord ExportJSON导出的一名玩家，ImportJSON按此恢复分数和同分排序时间
+type PlayerRecord struct {
+	PlayerID   string
+	Score      int64
+	Tiebreak   int64 // 次要排序值，见UpdateScoreWithTieBreak
+	UpdateTime time.Time
+}
+
+// ImportMode ImportJSON处理排行榜已有数据的方式
+type ImportMode int
+
+const (
+	// ImportReplace 用导入数据整体替换排行榜
+	ImportReplace ImportMode = iota
+	// ImportMerge 保留已有玩家，导入数据中的玩家覆盖同ID的玩家
+	ImportMerge
+)
+
+// readPlayerRecords 读取ExportJSON写出的JSON数组
+func readPlayerRecords(r io.Reader) ([]PlayerRecord, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected JSON array, got %v", tok)
+	}
+
+	var records []PlayerRecord
+	for dec.More() {
+		var rec PlayerRecord
+		if err := dec.Decode(&rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// jsonArrayWriter 逐个元素写出JSON数组，不在内存中拼接整个数组
+type jsonArrayWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+// newJSONArrayWriter 创建JSON数组写入器
+func newJSONArrayWriter(w io.Writer) *jsonArrayWriter {
+	return &jsonArrayWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// Write 写出一个数组元素，首个元素前写入"["，之后的元素前写入","
+func (a *jsonArrayWriter) Write(v interface{}) error {
+	sep := ","
+	if a.count == 0 {
+		sep = "["
+	}
+	if _, err := io.WriteString(a.w, sep); err != nil {
+		return err
+	}
+	a.count++
+	return a.enc.Encode(v)
+}
+
+// Close 写出数组结尾，没有元素时输出空数组
+func (a *jsonArrayWriter) Close() error {
+	end := "]"
+	if a.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// csvHeader ExportTopNCSV的表头
+var csvHeader = []string{"rank", "playerID", "score", "updateTime"}
+
+// writeCSVRow 写出一行排名，更新时间按RFC3339格式化，未记录更新时间时该列留空
+func writeCSVRow(cw *csv.Writer, pr PlayerRank) error {
+	updateTime := ""
+	if !pr.UpdateTime.IsZero() {
+		updateTime = pr.UpdateTime.Format(time.RFC3339)
+	}
+	return cw.Write([]string{strconv.Itoa(pr.Rank), pr.PlayerID, strconv.FormatInt(pr.Score, 10), updateTime})
+}
@@ -0,0 +1,229 @@
+package game_rank_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// hybridProbeInterval 降级后读写最多每隔多久尝试一次Redis
+const hybridProbeInterval = time.Second
+
+// HybridRanker Redis不可用时自动降级到内存副本的排行榜
+// 写入同时写Redis和内存，读取优先读Redis，Redis出现连接类错误时改读内存并进入降级状态；
+// 降级期间的写入只进入内存并记录玩家，Redis恢复后先把这些玩家的当前分数补写回Redis，再恢复读Redis
+// 内存副本只包含经过该实例的写入，需要完整副本时先用LoadFromRedis预热；补写的玩家更新时间为补写时刻
+type HybridRanker struct {
+	redis  *RedisRankingList
+	memory *RankingSystem
+
+	degraded  int32 // 1表示处于降级状态
+	mu        sync.Mutex
+	dirty     map[string]struct{} // 降级期间只写入了内存的玩家
+	lastProbe time.Time
+}
+
+var _ Ranker = (*HybridRanker)(nil)
+
+// NewHybridRanker 组合Redis排行榜和作为镜像的内存排行榜，两者应使用相同的配置
+func NewHybridRanker(list *RedisRankingList, memory *RankingSystem) *HybridRanker {
+	return &HybridRanker{
+		redis:  list,
+		memory: memory,
+		dirty:  make(map[string]struct{}),
+	}
+}
+
+// Degraded 是否处于降级状态，此时读取返回内存副本的数据，可能落后于其他实例的写入
+func (h *HybridRanker) Degraded() bool {
+	return atomic.LoadInt32(&h.degraded) == 1
+}
+
+// unavailable Redis错误是否表示Redis不可用，逻辑错误（如玩家不存在、分数越界）原样返回给调用方
+func unavailable(err error) bool {
+	return isTransient(err) || errors.Is(err, redis.ErrClosed) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// redisUsable 能否访问Redis；降级时按间隔探测并补写降级期间的写入，成功后恢复正常状态
+func (h *HybridRanker) redisUsable() bool {
+	if !h.Degraded() {
+		return true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.Degraded() {
+		return true
+	}
+	if time.Since(h.lastProbe) < hybridProbeInterval {
+		return false
+	}
+	h.lastProbe = time.Now()
+
+	if err := h.resync(); err != nil {
+		return false
+	}
+	atomic.StoreInt32(&h.degraded, 0)
+	return true
+}
+
+// resync 把降级期间写入的玩家的内存状态补写回Redis，调用方需持有mu
+func (h *HybridRanker) resync() error {
+	if err := h.redis.client.Ping(h.redis.ctx).Err(); err != nil {
+		return err
+	}
+
+	updates := make(map[string]int64, len(h.dirty))
+	var removed []string
+	for playerID := range h.dirty {
+		score, err := h.memory.GetScore(playerID)
+		if errors.Is(err, ErrPlayerNotFound) {
+			removed = append(removed, playerID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		updates[playerID] = score
+	}
+
+	if err := h.redis.UpdateScores(updates); err != nil {
+		return err
+	}
+	if _, err := h.redis.RemovePlayers(removed); err != nil {
+		return err
+	}
+	h.dirty = make(map[string]struct{})
+	return nil
+}
+
+// degrade 进入降级状态并记录只写入了内存的玩家
+func (h *HybridRanker) degrade(playerID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if playerID != "" {
+		h.dirty[playerID] = struct{}{}
+	}
+	if atomic.CompareAndSwapInt32(&h.degraded, 0, 1) {
+		h.lastProbe = time.Now()
+	}
+}
+
+// write 先写Redis，逻辑错误直接返回；Redis不可用时降级，只写内存
+func (h *HybridRanker) write(playerID string, toRedis, toMemory func() error) error {
+	if h.redisUsable() {
+		err := toRedis()
+		if err == nil {
+			return toMemory()
+		}
+		if !unavailable(err) {
+			return err
+		}
+	}
+	h.degrade(playerID)
+	return toMemory()
+}
+
+// UpdateScore 更新玩家积分，Redis不可用时只写入内存，恢复后补写
+func (h *HybridRanker) UpdateScore(playerID string, score int64) error {
+	// 降级时也按Redis的范围校验，避免恢复后补写失败
+	if err := h.redis.codec.check(h.redis.opts.orient(h.redis.opts.quantize(score))); err != nil {
+		return err
+	}
+	return h.write(playerID,
+		func() error { return h.redis.UpdateScore(playerID, score) },
+		func() error { return h.memory.UpdateScore(playerID, score) })
+}
+
+// RemovePlayer 移除玩家，Redis不可用时只从内存移除，恢复后补删；wasPresent以实际执行移除的一方为准
+func (h *HybridRanker) RemovePlayer(playerID string) (wasPresent bool, err error) {
+	var present bool
+	err = h.write(playerID,
+		func() error {
+			var err error
+			present, err = h.redis.RemovePlayer(playerID)
+			return err
+		},
+		func() error {
+			inMemory, err := h.memory.RemovePlayer(playerID)
+			if h.Degraded() {
+				present = inMemory
+			}
+			return err
+		})
+	return present, err
+}
+
+// read 优先读Redis，Redis不可用时降级读内存
+func (h *HybridRanker) read(fromRedis, fromMemory func() error) error {
+	if h.redisUsable() {
+		err := fromRedis()
+		if !unavailable(err) {
+			return err
+		}
+		h.degrade("")
+	}
+	return fromMemory()
+}
+
+// GetRank 查询玩家当前名次和分数
+func (h *HybridRanker) GetRank(playerID string) (rank int, score int64, err error) {
+	err = h.read(
+		func() error { rank, score, err = h.redis.GetRank(playerID); return err },
+		func() error { rank, score, err = h.memory.GetRank(playerID); return err })
+	return rank, score, err
+}
+
+// GetScore 查询玩家当前分数，玩家不存在时返回ErrPlayerNotFound
+func (h *HybridRanker) GetScore(playerID string) (score int64, err error) {
+	err = h.read(
+		func() error { score, err = h.redis.GetScore(playerID); return err },
+		func() error { score, err = h.memory.GetScore(playerID); return err })
+	return score, err
+}
+
+// Exists 玩家是否在榜
+func (h *HybridRanker) Exists(playerID string) (exists bool, err error) {
+	err = h.read(
+		func() error { exists, err = h.redis.Exists(playerID); return err },
+		func() error { exists, err = h.memory.Exists(playerID); return err })
+	return exists, err
+}
+
+// GetTopN 获取前N名玩家的分数和名次
+func (h *HybridRanker) GetTopN(n int) (rankings []PlayerRank, err error) {
+	err = h.read(
+		func() error { rankings, err = h.redis.GetTopN(n); return err },
+		func() error { rankings, err = h.memory.GetTopN(n); return err })
+	return rankings, err
+}
+
+// GetRankPage 获取从offset（从0开始）起的limit名玩家
+func (h *HybridRanker) GetRankPage(offset, limit int) (rankings []PlayerRank, err error) {
+	err = h.read(
+		func() error { rankings, err = h.redis.GetRankPage(offset, limit); return err },
+		func() error { rankings, err = h.memory.GetRankPage(offset, limit); return err })
+	return rankings, err
+}
+
+// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
+func (h *HybridRanker) GetPlayerRankRange(playerID string, n int) (rankings []PlayerRank, err error) {
+	err = h.read(
+		func() error { rankings, err = h.redis.GetPlayerRankRange(playerID, n); return err },
+		func() error { rankings, err = h.memory.GetPlayerRankRange(playerID, n); return err })
+	return rankings, err
+}
+
+// GetTotalPlayers 获取总玩家数
+func (h *HybridRanker) GetTotalPlayers() (total int64, err error) {
+	err = h.read(
+		func() error { total, err = h.redis.GetTotalPlayers(); return err },
+		func() error { total, err = h.memory.GetTotalPlayers(); return err })
+	return total, err
+}
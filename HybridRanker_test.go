@@ -0,0 +1,125 @@
+package game_rank_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestHybrid 基于miniredis的HybridRanker，客户端不重试，Redis关闭后立即返回连接错误
+func newTestHybrid(t *testing.T) (*HybridRanker, *RedisRankingList, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	list, err := NewRedisRankingWithClient(client, "rank:test")
+	if err != nil {
+		t.Fatalf("NewRedisRankingWithClient: %v", err)
+	}
+	return NewHybridRanker(list, NewRankingSystem()), list, mr
+}
+
+// skipProbeInterval 让下一次读写立即探测Redis，不必等待hybridProbeInterval
+func skipProbeInterval(h *HybridRanker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastProbe = time.Time{}
+}
+
+// TestHybridRankerDegrade Redis关闭后写入和读取都降级到内存，Degraded为true；探测间隔内Redis恢复也继续读内存
+func TestHybridRankerDegrade(t *testing.T) {
+	h, _, mr := newTestHybrid(t)
+	for id, score := range map[string]int64{"a": 10, "b": 20} {
+		if err := h.UpdateScore(id, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Degraded() {
+		t.Fatal("degraded while Redis is up")
+	}
+
+	mr.Close()
+	if err := h.UpdateScore("c", 30); err != nil {
+		t.Fatalf("UpdateScore with Redis down: %v", err)
+	}
+	if !h.Degraded() {
+		t.Fatal("not degraded after a Redis connection error")
+	}
+	if got := topIDs(t, h, 10); !equalIDs(got, []string{"c", "b", "a"}) {
+		t.Errorf("top from memory = %v, want [c b a]", got)
+	}
+	if rank, score, err := h.GetRank("c"); err != nil || rank != 1 || score != 30 {
+		t.Errorf("GetRank(c) = %d, %d, %v, want 1, 30", rank, score, err)
+	}
+
+	mr.Restart()
+	if _, err := h.GetTotalPlayers(); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Degraded() {
+		t.Error("recovered before the probe interval elapsed")
+	}
+}
+
+// TestHybridRankerReadFallback 只有读取遇到Redis错误时同样降级，读取结果来自内存副本
+func TestHybridRankerReadFallback(t *testing.T) {
+	h, _, mr := newTestHybrid(t)
+	if err := h.UpdateScore("a", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.Close()
+	if score, err := h.GetScore("a"); err != nil || score != 10 {
+		t.Errorf("GetScore(a) = %d, %v, want 10", score, err)
+	}
+	if !h.Degraded() {
+		t.Error("not degraded after a failed read")
+	}
+	if total, err := h.GetTotalPlayers(); err != nil || total != 1 {
+		t.Errorf("GetTotalPlayers = %d, %v, want 1", total, err)
+	}
+}
+
+// TestHybridRankerResync Redis恢复后下一次探测补写降级期间的更新和移除，然后恢复读Redis
+func TestHybridRankerResync(t *testing.T) {
+	h, list, mr := newTestHybrid(t)
+	for id, score := range map[string]int64{"a": 10, "b": 20} {
+		if err := h.UpdateScore(id, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mr.Close()
+	if err := h.UpdateScore("c", 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.UpdateScore("a", 40); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RemovePlayer("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.Restart()
+	skipProbeInterval(h)
+	if got := topIDs(t, h, 10); !equalIDs(got, []string{"a", "c"}) {
+		t.Errorf("top after reconnect = %v, want [a c]", got)
+	}
+	if h.Degraded() {
+		t.Fatal("still degraded after Redis came back")
+	}
+
+	// 直接读Redis确认补写的结果
+	for id, want := range map[string]int64{"a": 40, "c": 30} {
+		if score, err := list.GetScore(id); err != nil || score != want {
+			t.Errorf("Redis GetScore(%s) = %d, %v, want %d", id, score, err, want)
+		}
+	}
+	if exists, err := list.Exists("b"); err != nil || exists {
+		t.Errorf("Redis Exists(b) = %v, %v, want false", exists, err)
+	}
+}
@@ -0,0 +1,138 @@
+package game_rank_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LeaderboardManager 用同一个Redis客户端管理多个按名称区分的排行榜，例如pvp、coop、weekly
+// 排行榜的键为prefix+name，所有排行榜共用创建管理器时的配置；已创建的名称记录在键prefix对应的集合中
+type LeaderboardManager struct {
+	client *redis.Client
+	prefix string
+	opts   []Option
+	boards map[string]*RedisRankingList
+	mu     sync.Mutex
+}
+
+// NewLeaderboardManager 创建排行榜管理器，客户端的生命周期仍由调用方管理
+func NewLeaderboardManager(client *redis.Client, prefix string, opts ...Option) *LeaderboardManager {
+	return &LeaderboardManager{
+		client: client,
+		prefix: prefix,
+		opts:   opts,
+		boards: make(map[string]*RedisRankingList),
+	}
+}
+
+// GetBoard 获取名为name的排行榜，首次获取时创建并登记，之后返回同一个实例
+func (m *LeaderboardManager) GetBoard(name string) (*RedisRankingList, error) {
+	if name == "" {
+		return nil, fmt.Errorf("排行榜名称不能为空")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if board, ok := m.boards[name]; ok {
+		return board, nil
+	}
+
+	board, err := NewRedisRankingWithClient(m.client, m.prefix+name, m.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.client.SAdd(context.Background(), m.prefix, name).Err(); err != nil {
+		return nil, fmt.Errorf("登记排行榜失败: %w", err)
+	}
+	m.boards[name] = board
+	return board, nil
+}
+
+// ListBoards 按名称排序返回所有已登记的排行榜，包括其他进程创建的
+func (m *LeaderboardManager) ListBoards() ([]string, error) {
+	names, err := m.client.SMembers(context.Background(), m.prefix).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取排行榜列表失败: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteBoard 清空并注销名为name的排行榜，排名基线和已归档的赛季不受影响
+func (m *LeaderboardManager) DeleteBoard(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	board, ok := m.boards[name]
+	if !ok {
+		var err error
+		if board, err = NewRedisRankingWithClient(m.client, m.prefix+name, m.opts...); err != nil {
+			return err
+		}
+	}
+	if err := board.Clear(); err != nil {
+		return err
+	}
+	if err := m.client.SRem(context.Background(), m.prefix, name).Err(); err != nil {
+		return fmt.Errorf("注销排行榜失败: %w", err)
+	}
+	delete(m.boards, name)
+	return nil
+}
+
+// MemoryLeaderboardManager 管理多个按名称区分的内存排行榜
+type MemoryLeaderboardManager struct {
+	opts   []Option
+	boards map[string]*RankingSystem
+	mu     sync.Mutex
+}
+
+// NewMemoryLeaderboardManager 创建内存排行榜管理器，所有排行榜共用opts
+func NewMemoryLeaderboardManager(opts ...Option) *MemoryLeaderboardManager {
+	return &MemoryLeaderboardManager{
+		opts:   opts,
+		boards: make(map[string]*RankingSystem),
+	}
+}
+
+// GetBoard 获取名为name的排行榜，不存在时创建，之后返回同一个实例
+func (m *MemoryLeaderboardManager) GetBoard(name string) *RankingSystem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	board, ok := m.boards[name]
+	if !ok {
+		board = NewRankingSystem(m.opts...)
+		m.boards[name] = board
+	}
+	return board
+}
+
+// ListBoards 按名称排序返回所有排行榜
+func (m *MemoryLeaderboardManager) ListBoards() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.boards))
+	for name := range m.boards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeleteBoard 删除名为name的排行榜，已取得的实例仍可使用但不再由管理器返回
+// existed表示删除前排行榜是否存在
+func (m *MemoryLeaderboardManager) DeleteBoard(name string) (existed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, existed = m.boards[name]
+	delete(m.boards, name)
+	return existed
+}
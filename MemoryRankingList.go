@@ -1,8 +1,16 @@
 package game_rank_test
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/gob"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -12,198 +20,1405 @@ import (
 type Player struct {
 	ID         string
 	Score      int64
-	UpdateTime time.Time // 记录分数最后更新时间，用于同分排序
+	Tiebreak   int64      // 次要排序值，同分时越大越靠前，优先于更新时间，见UpdateScoreWithTieBreak
+	UpdateTime time.Time  // 记录分数最后更新时间，用于同分排序
+	Meta       PlayerMeta // 展示用的元数据，更新分数不影响
+}
+
+// playerRank 由玩家生成排名信息
+func playerRank(p *Player, rank int) PlayerRank {
+	return PlayerRank{
+		PlayerID:   p.ID,
+		Score:      p.Score,
+		Rank:       rank,
+		UpdateTime: p.UpdateTime,
+		Meta:       p.Meta,
+	}
 }
 
 // RankingSystem 排行榜系统
 type RankingSystem struct {
 	players map[string]*Player
-	ranks   []*Player
+	ranks   *rankList // 按排名顺序增量维护，更新和查询名次都是O(log n)
 	mu      sync.RWMutex
+	opts    options
+
+	version      uint64               // 排名或元数据每次变化时递增，用于生成ETag
+	baselines    map[string]*baseline // 按名称保存的排名基线，名次快照也保存在这里
+	snapshotSeq  uint64               // 上一个名次快照的编号
+	onRankChange RankChangeFunc       // UpdateScore改变玩家名次时调用
+}
+
+// baseline 某一时刻的全榜名次快照
+type baseline struct {
+	ranks map[string]int
+	total int
 }
 
 // NewRankingSystem 创建一个新的排行榜系统
-func NewRankingSystem() *RankingSystem {
+func NewRankingSystem(opts ...Option) *RankingSystem {
+	o := newOptions(opts)
 	return &RankingSystem{
-		players: make(map[string]*Player),
-		ranks:   make([]*Player, 0),
+		players:   make(map[string]*Player),
+		ranks:     o.newRankList(),
+		opts:      o,
+		baselines: make(map[string]*baseline),
 	}
 }
 
 // UpdateScore 更新玩家积分
 // 如果玩家不存在则创建，存在则更新分数和时间戳
-func (r *RankingSystem) UpdateScore(playerID string, score int64) {
+// 分数不变时是否刷新时间戳由TiePolicy决定，默认KeepFirst不刷新
+func (r *RankingSystem) UpdateScore(playerID string, score int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScore", time.Now(), &err)
+	return r.updateScore(playerID, score, 0)
+}
+
+// UpdateScoreWithTieBreak 同UpdateScore，同时写入次要排序值tiebreak，同分时tiebreak越大越靠前，再相同才按更新时间
+// 例如按死亡次数越少越好时传入负的死亡次数；只决定同分玩家的先后，名次仍按分数并列；其他写入方法的次要排序值都按0处理
+func (r *RankingSystem) UpdateScoreWithTieBreak(playerID string, score, tiebreak int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScoreWithTieBreak", time.Now(), &err)
+	return r.updateScore(playerID, score, tiebreak)
+}
+
+// updateScore 写入原始分数和次要排序值并触发名次变化回调
+func (r *RankingSystem) updateScore(playerID string, score, tiebreak int64) error {
+	r.mu.Lock()
+	notify := r.onRankChange
+	oldRank := r.notifiedRank(playerID)
+	r.setScore(playerID, r.opts.orient(r.opts.quantize(score)), tiebreak, r.opts.now())
+	newRank := r.notifiedRank(playerID)
+	r.mu.Unlock()
+
+	// 在锁外回调，回调中可以再调用排行榜的方法
+	if notify != nil && oldRank != newRank {
+		notify(playerID, oldRank, newRank)
+	}
+	return nil
+}
+
+// OnRankChange 注册名次变化回调，UpdateScore、UpdateScoreWithTieBreak、UpdateScores、IncrementScore和UpdateScoreIfHigher
+// 使被更新玩家的名次变化时在锁外同步调用；EnsurePlayer、BulkLoad、Merge等其他写入不回调
+// 只报告被更新的玩家，因此被挤下的其他玩家不会收到回调；传nil取消注册
+func (r *RankingSystem) OnRankChange(fn RankChangeFunc) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.onRankChange = fn
+}
+
+// notifiedRank 注册了名次变化回调时返回玩家当前名次，玩家不在榜或未注册回调时返回0，调用方需持有锁
+func (r *RankingSystem) notifiedRank(playerID string) int {
+	if r.onRankChange == nil {
+		return 0
+	}
 	if player, exists := r.players[playerID]; exists {
-		// 只有当分数变化时才更新时间戳，保证先达到高分的玩家排在前面
-		if player.Score != score {
-			player.Score = score
-			player.UpdateTime = time.Now()
+		return r.rankOf(player)
+	}
+	return 0
+}
+
+// UpdateScores 批量更新玩家积分，所有更新在一次加锁内完成
+// 每个玩家的处理与UpdateScore相同
+func (r *RankingSystem) UpdateScores(updates map[string]int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScores", time.Now(), &err)
+	r.mu.Lock()
+	notify := r.onRankChange
+	var oldRanks map[string]int
+	if notify != nil {
+		oldRanks = make(map[string]int, len(updates))
+		for playerID := range updates {
+			oldRanks[playerID] = r.notifiedRank(playerID)
 		}
-	} else {
-		// 新玩家
-		r.players[playerID] = &Player{
-			ID:         playerID,
-			Score:      score,
-			UpdateTime: time.Now(),
+	}
+
+	now := r.opts.now()
+	for playerID, score := range updates {
+		r.setScore(playerID, r.opts.orient(r.opts.quantize(score)), 0, now)
+	}
+
+	// 全部写入后再统计新名次，回调报告的是整批写入前后的名次
+	newRanks := make(map[string]int, len(oldRanks))
+	for playerID := range oldRanks {
+		newRanks[playerID] = r.notifiedRank(playerID)
+	}
+	r.mu.Unlock()
+
+	for playerID, oldRank := range oldRanks {
+		if newRank := newRanks[playerID]; newRank != oldRank {
+			notify(playerID, oldRank, newRank)
+		}
+	}
+	return nil
+}
+
+// IncrementScore 在玩家当前分数上增加delta（可为负），玩家不存在时从0开始，返回新分数
+// 读取和写入在同一次加锁内完成，并发增量不会丢失；增量不经过ScoreQuantizer
+func (r *RankingSystem) IncrementScore(playerID string, delta int64) (newScore int64, err error) {
+	defer r.opts.observeUpdate("IncrementScore", time.Now(), &err)
+	r.mu.Lock()
+	notify := r.onRankChange
+	oldRank := r.notifiedRank(playerID)
+
+	var score int64
+	if player, exists := r.players[playerID]; exists {
+		score = player.Score
+	}
+	score += r.opts.orient(delta)
+
+	r.setScore(playerID, score, 0, r.opts.now())
+	newRank := r.notifiedRank(playerID)
+	r.mu.Unlock()
+
+	if notify != nil && oldRank != newRank {
+		notify(playerID, oldRank, newRank)
+	}
+	return r.opts.orient(score), nil
+}
+
+// UpdateScoreIfHigher 只有新分数高于当前分数（或玩家不在榜）时才写入，用于只记录个人最佳的排行榜
+// updated表示本次是否写入
+func (r *RankingSystem) UpdateScoreIfHigher(playerID string, score int64) (updated bool, err error) {
+	defer r.opts.observeUpdate("UpdateScoreIfHigher", time.Now(), &err)
+	r.mu.Lock()
+	score = r.opts.orient(r.opts.quantize(score))
+	if player, exists := r.players[playerID]; exists && player.Score >= score {
+		r.mu.Unlock()
+		return false, nil
+	}
+
+	notify := r.onRankChange
+	oldRank := r.notifiedRank(playerID)
+	r.setScore(playerID, score, 0, r.opts.now())
+	newRank := r.notifiedRank(playerID)
+	r.mu.Unlock()
+
+	if notify != nil && oldRank != newRank {
+		notify(playerID, oldRank, newRank)
+	}
+	return true, nil
+}
+
+// setScore 写入单个玩家的分数和次要排序值并调整其在跳表中的位置，调用方需持有写锁
+func (r *RankingSystem) setScore(playerID string, score, tiebreak int64, now time.Time) {
+	if player, exists := r.players[playerID]; exists {
+		// 默认只有当分数变化时才更新时间戳，保证先达到高分的玩家排在前面
+		if player.Score != score || player.Tiebreak != tiebreak || r.opts.tiePolicy == RefreshOnResubmit {
+			r.ranks.remove(player)
+			player.Score = score
+			player.Tiebreak = tiebreak
+			player.UpdateTime = now
+			r.ranks.insert(player)
+			r.version++
 		}
+		return
+	}
+
+	// 新玩家
+	r.addPlayer(&Player{
+		ID:         playerID,
+		Score:      score,
+		Tiebreak:   tiebreak,
+		UpdateTime: now,
+	})
+}
+
+// addPlayer 加入新玩家，配置了WithMaxSize时随即裁掉超出人数上限的最后几名，调用方需持有写锁
+func (r *RankingSystem) addPlayer(p *Player) {
+	r.players[p.ID] = p
+	r.ranks.insert(p)
+	r.version++
+
+	for r.opts.maxSize > 0 && r.ranks.len() > r.opts.maxSize {
+		last := r.ranks.at(r.ranks.len() - 1)
+		r.ranks.remove(last)
+		delete(r.players, last.ID)
+	}
+}
+
+// EnsurePlayer 玩家不存在时以0分加入，已存在时不覆盖原分数
+// created表示本次是否新加入了玩家
+func (r *RankingSystem) EnsurePlayer(playerID string) (created bool, err error) {
+	defer r.opts.observeUpdate("EnsurePlayer", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.players[playerID]; exists {
+		return false, nil
 	}
-	r.ranks = r.getSortedPlayers()
+
+	r.addPlayer(&Player{
+		ID:         playerID,
+		UpdateTime: r.opts.now(),
+	})
+	return true, nil
 }
 
 // GetRank 查询玩家当前排名
-func (r *RankingSystem) GetRank(playerID string) (int, *Player, error) {
+func (r *RankingSystem) GetRank(playerID string) (rank int, score int64, err error) {
+	defer r.opts.observeQuery("GetRank", time.Now(), &err)
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	// 检查玩家是否存在
-	_, exists := r.players[playerID]
+	player, exists := r.players[playerID]
 	if !exists {
-		return 0, nil, fmt.Errorf("player %s not found", playerID)
-	}
-
-	// 查找玩家排名
-	rank := 1
-	for i, p := range r.ranks {
-		if p.ID == playerID {
-			// 考虑并列排名的情况
-			if i > 0 && r.ranks[i-1].Score == p.Score {
-				rank = i // 与前一名并列
-			} else {
-				rank = i + 1 // 新的排名
-			}
-			return rank, p, nil
-		}
+		return 0, 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	return r.opts.outRank(r.rankOf(player)), r.opts.orient(player.Score), nil
+}
+
+// GetRankDetail 同GetRank，同时返回总人数，用于展示“第42名/共9000人”，三者在同一把读锁下读取
+func (r *RankingSystem) GetRankDetail(playerID string) (rank int, score int64, total int64, err error) {
+	defer r.opts.observeQuery("GetRankDetail", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	return r.opts.outRank(r.rankOf(player)), r.opts.orient(player.Score), int64(len(r.players)), nil
+}
+
+// GetRankForScore 预估以score上榜时的名次，不写入任何数据
+// 分数先按ScoreQuantizer量化，同分视为刚达到该分数，与已有同分玩家并列
+func (r *RankingSystem) GetRankForScore(score int64) (_ int, err error) {
+	defer r.opts.observeQuery("GetRankForScore", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.opts.outRank(r.rankAbove(r.opts.orient(r.opts.quantize(score))) + 1), nil
+}
+
+// GetPercentile 返回玩家名次超过的玩家比例（0到1），例如0.97表示排在前3%
+// 按(总人数-名次)/总人数计算，只有一名玩家时返回1
+func (r *RankingSystem) GetPercentile(playerID string) (_ float64, err error) {
+	defer r.opts.observeQuery("GetPercentile", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	return percentile(r.ranks.countAbove(player.Score)+1, int64(r.ranks.len())), nil
+}
+
+// percentile 由名次和总人数计算超过的玩家比例
+func percentile(rank int, total int64) float64 {
+	if total <= 1 {
+		return 1
+	}
+	return float64(total-int64(rank)) / float64(total)
+}
+
+// GetScore 查询玩家当前分数，不计算名次
+func (r *RankingSystem) GetScore(playerID string) (score int64, err error) {
+	defer r.opts.observeQuery("GetScore", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
 	}
+	return r.opts.orient(player.Score), nil
+}
 
-	return 0, nil, fmt.Errorf("player %s not found in ranking", playerID)
+// Exists 玩家是否在榜
+func (r *RankingSystem) Exists(playerID string) (present bool, err error) {
+	defer r.opts.observeQuery("Exists", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, exists := r.players[playerID]
+	return exists, nil
 }
 
 // GetTopN 获取前N名玩家的分数和名次
-func (r *RankingSystem) GetTopN(n int) ([]struct {
-	Rank   int
-	Player *Player
-}, error) {
+func (r *RankingSystem) GetTopN(n int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopN", time.Now(), &err)
 	if n <= 0 {
-		return nil, fmt.Errorf("n must be greater than 0")
+		return nil, ErrInvalidN
 	}
 
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	length := len(r.ranks)
-	result := make([]struct {
-		Rank   int
-		Player *Player
-	}, 0, min(n, length))
 
-	// 计算排名，处理并列情况
-	for i := 0; i < length && i < n; i++ {
-		rank := i + 1
-		// 如果当前玩家与前一位分数相同，则排名相同
-		if i > 0 && r.ranks[i].Score == r.ranks[i-1].Score {
-			rank = result[i-1].Rank
-		}
+	return r.rankPage(0, n), nil
+}
 
-		result = append(result, struct {
-			Rank   int
-			Player *Player
-		}{rank, r.ranks[i]})
+// GetTopNApprox 获取前N名，Rank为按位置的1..n，同分玩家不合并为并列名次
+// 与GetTopN的区别只在名次：GetTopN中同分玩家名次相同，这里依次递增；顺序和分数两者一致，省去计算首名次的查找
+func (r *RankingSystem) GetTopNApprox(n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopNApprox", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
 	}
 
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	players := r.ranks.page(0, n)
+	result := make([]PlayerRank, len(players))
+	for i, p := range players {
+		result[i] = r.opts.playerRank(p, i+1)
+	}
 	return result, nil
 }
 
-// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
-func (r *RankingSystem) GetPlayerRankRange(playerID string, n int) ([]struct {
-	Rank   int
-	Player *Player
-}, error) {
+// GetTopNMap 以玩家ID为键返回前N名，便于O(1)判断玩家是否在前N名及其名次
+// map不保留顺序，需要顺序时使用GetTopN
+func (r *RankingSystem) GetTopNMap(n int) (_ map[string]PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopNMap", time.Now(), &err)
 	if n <= 0 {
-		return nil, fmt.Errorf("n must be greater than 0")
+		return nil, ErrInvalidN
 	}
 
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// 检查玩家是否存在
-	if _, exists := r.players[playerID]; !exists {
-		return nil, fmt.Errorf("player %s not found", playerID)
+	return rankingsMap(r.rankPage(0, n)), nil
+}
+
+// GetTopNWithETag 获取前N名及对应的ETag，排名和元数据未变化时ETag不变
+func (r *RankingSystem) GetTopNWithETag(n int) (_ []PlayerRank, _ string, err error) {
+	defer r.opts.observeQuery("GetTopNWithETag", time.Now(), &err)
+	if n <= 0 {
+		return nil, "", ErrInvalidN
 	}
 
-	sortedPlayers := r.getSortedPlayers()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	// 找到玩家位置
-	index := -1
-	for i, p := range sortedPlayers {
-		if p.ID == playerID {
-			index = i
-			break
-		}
+	return r.rankPage(0, n), r.etag(n), nil
+}
+
+// GetTopNIfChanged ETag与当前一致时返回false和nil切片，调用方可直接响应304
+func (r *RankingSystem) GetTopNIfChanged(n int, etag string) (_ []PlayerRank, _ string, _ bool, err error) {
+	defer r.opts.observeQuery("GetTopNIfChanged", time.Now(), &err)
+	if n <= 0 {
+		return nil, "", false, ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	current := r.etag(n)
+	if current == etag {
+		return nil, current, false, nil
 	}
+	return r.rankPage(0, n), current, true, nil
+}
+
+// GetRankPage 获取从offset（从0开始）起的limit名玩家，用于分页展示
+// 名次按全榜并列规则计算，页首在并列中间时名次与上一页末尾一致
+func (r *RankingSystem) GetRankPage(offset, limit int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankPage", time.Now(), &err)
+	return r.checkedRankPage(offset, limit)
+}
 
-	if index == -1 {
-		return nil, fmt.Errorf("player %s not found in ranking", playerID)
+// checkedRankPage 同GetRankPage，不上报监控，供其他已上报的方法内部调用
+func (r *RankingSystem) checkedRankPage(offset, limit int) ([]PlayerRank, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative", ErrInvalidRange)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", ErrInvalidRange)
 	}
 
-	// 计算需要获取的范围
-	half := n / 2
-	start := max(0, index-half)
-	end := min(len(sortedPlayers), start+n)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	// 调整start，确保能取到足够的玩家
-	if end-start < n {
-		start = max(0, end-n)
+	return r.rankPage(offset, limit), nil
+}
+
+// GetRankRange 获取第startRank到第endRank位（从1开始，含两端）的玩家，按位置而非并列名次截取
+// endRank超过总人数时返回到最后一名为止，startRank超过总人数时返回空列表
+func (r *RankingSystem) GetRankRange(startRank, endRank int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankRange", time.Now(), &err)
+	if startRank < 1 {
+		return nil, fmt.Errorf("%w: startRank must be at least 1", ErrInvalidRange)
+	}
+	if endRank < startRank {
+		return nil, fmt.Errorf("%w: endRank must not be less than startRank", ErrInvalidRange)
+	}
+	return r.checkedRankPage(startRank-1, endRank-startRank+1)
+}
+
+// GetBottomN 获取最后N名玩家，按名次从前到后排列，名次为全榜名次
+func (r *RankingSystem) GetBottomN(n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetBottomN", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
 	}
 
-	result := make([]struct {
-		Rank   int
-		Player *Player
-	}, 0, end-start)
-
-	// 填充结果并计算排名
-	for i := start; i < end; i++ {
-		rank := i + 1
-		if i > 0 && sortedPlayers[i].Score == sortedPlayers[i-1].Score {
-			// 找到前一个不同分的玩家，计算正确排名
-			for j := i - 1; j >= 0; j-- {
-				if sortedPlayers[j].Score != sortedPlayers[i].Score {
-					rank = j + 2
-					break
-				}
-				if j == 0 {
-					rank = 1
-				}
-			}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.rankPage(max(0, r.ranks.len()-n), n), nil
+}
+
+// rankPage 获取从offset起的limit名玩家，页首名次按更高分人数计算，调用方需持有读锁
+func (r *RankingSystem) rankPage(offset, limit int) []PlayerRank {
+	players := r.ranks.page(offset, limit)
+	if len(players) == 0 {
+		return []PlayerRank{}
+	}
+
+	rank := r.rankOf(players[0])
+	result := make([]PlayerRank, 0, len(players))
+	for i, p := range players {
+		if i > 0 && p.Score != players[i-1].Score {
+			rank = r.opts.nextRank(rank, offset+i+1)
 		}
+		result = append(result, r.opts.playerRank(p, rank))
+	}
+	return result
+}
+
+// rankOf 计算玩家的名次，同分并列取并列中的第一名，调用方需持有读锁
+func (r *RankingSystem) rankOf(p *Player) int {
+	return r.rankAbove(p.Score) + 1
+}
+
+// rankAbove 名次排在内部分数score之前的名次数：默认为分数更高的人数，DenseRanking时为更高的不同分数个数，调用方需持有读锁
+func (r *RankingSystem) rankAbove(score int64) int {
+	if r.opts.rankMode == DenseRanking {
+		return r.ranks.countDistinctAbove(score)
+	}
+	return r.ranks.countAbove(score)
+}
+
+// etag 由排名版本和N生成ETag，调用方需持有读锁
+func (r *RankingSystem) etag(n int) string {
+	return fmt.Sprintf("%x-%d", r.version, n)
+}
 
-		result = append(result, struct {
-			Rank   int
-			Player *Player
-		}{rank, sortedPlayers[i]})
+// GetTopNTieBreak 获取前N名及同分时实际比较的值，用于排查同分先后的争议，比较规则见TieBreakEntry
+func (r *RankingSystem) GetTopNTieBreak(n int) (_ []TieBreakEntry, err error) {
+	defer r.opts.observeQuery("GetTopNTieBreak", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// rankPage与跳表的分页顺序相同，按下标对应到玩家取比较时用的值
+	players := r.ranks.page(0, n)
+	page := r.rankPage(0, n)
+	entries := make([]TieBreakEntry, 0, len(page))
+	for i, pr := range page {
+		entries = append(entries, TieBreakEntry{
+			PlayerRank: pr,
+			Tiebreak:   players[i].Tiebreak,
+			TieBreak:   players[i].UpdateTime.Truncate(time.Second),
+		})
+	}
+
+	return entries, nil
+}
+
+// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
+// n<=0时使用配置的默认窗口大小；玩家前面取(n-1)/2名（向下取整），其余在后面，n为偶数时后面多一名；
+// 靠近榜首或榜尾时窗口整体平移，总是返回min(n, 总人数)名
+// Rank为全榜名次，Position为在返回窗口中的位置（从1开始）
+func (r *RankingSystem) GetPlayerRankRange(playerID string, n int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayerRankRange", time.Now(), &err)
+	if n <= 0 {
+		n = r.opts.defaultWindow
+	}
+	return r.playerRankRange(playerID, n)
+}
+
+// GetPlayerRankRangeStrict 同GetPlayerRankRange，但n<=0时返回错误
+func (r *RankingSystem) GetPlayerRankRangeStrict(playerID string, n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayerRankRangeStrict", time.Now(), &err)
+	return r.playerRankRange(playerID, n)
+}
+
+// playerRankRange 同GetPlayerRankRangeStrict，不上报监控
+func (r *RankingSystem) playerRankRange(playerID string, n int) ([]PlayerRank, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	// 计算需要获取的范围，玩家下标和窗口都直接取自增量维护的跳表
+	start, end := rankWindow(r.ranks.indexOf(player), r.ranks.len(), n)
+	result := r.rankPage(start, end-start)
+	for i := range result {
+		result[i].Position = i + 1
 	}
 
 	return result, nil
 }
 
-// getSortedPlayers 返回按排名规则排序的玩家列表
-func (r *RankingSystem) getSortedPlayers() []*Player {
-	// 将map转换为切片
-	players := make([]*Player, 0, len(r.players))
-	for _, p := range r.players {
-		players = append(players, p)
+// GetPlayerRankRangeWrap 同GetPlayerRankRange，但窗口到达榜首或榜尾时从另一端补齐，用于轮播展示
+// 人数足够时总是返回n名，补进来的玩家保留真实的全榜名次；人数不足n时返回全榜
+func (r *RankingSystem) GetPlayerRankRangeWrap(playerID string, n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayerRankRangeWrap", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
 	}
 
-	// 排序：先按分数降序，分数相同则按更新时间升序（先达到该分数的排前面）
-	sort.Slice(players, func(i, j int) bool {
-		if players[i].Score != players[j].Score {
-			return players[i].Score > players[j].Score
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	index := r.ranks.indexOf(player)
+	total := r.ranks.len()
+	start := index - (n-1)/2
+	if n >= total {
+		n, start = total, 0
+	}
+
+	result := make([]PlayerRank, 0, n)
+	for j := 0; j < n; j++ {
+		p := r.ranks.at(((start+j)%total + total) % total)
+		pr := r.opts.playerRank(p, r.rankOf(p))
+		pr.Position = j + 1
+		result = append(result, pr)
+	}
+
+	return result, nil
+}
+
+// RankBandScores 返回名次区间[startRank, endRank]首尾两名的分数，用于描述奖励档位的分数边界
+// endRank超出总人数时按最后一名计算
+func (r *RankingSystem) RankBandScores(startRank, endRank int) (highScore, lowScore int64, err error) {
+	defer r.opts.observeQuery("RankBandScores", time.Now(), &err)
+	if startRank < 1 || endRank < startRank {
+		return 0, 0, fmt.Errorf("%w: invalid rank band %d-%d", ErrInvalidRange, startRank, endRank)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	total := r.ranks.len()
+	if startRank > total {
+		return 0, 0, fmt.Errorf("%w: rank %d exceeds total players %d", ErrNotEnoughPlayers, startRank, total)
+	}
+	end := min(endRank, total)
+
+	return r.opts.orient(r.ranks.at(startRank - 1).Score), r.opts.orient(r.ranks.at(end - 1).Score), nil
+}
+
+// RankRangeByPercentile 获取百分位区间[lowPct, highPct)内的玩家，名次为全榜名次
+// 例如0和0.05表示前5%的玩家
+func (r *RankingSystem) RankRangeByPercentile(lowPct, highPct float64) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("RankRangeByPercentile", time.Now(), &err)
+	if lowPct < 0 || lowPct >= highPct || highPct > 1 {
+		return nil, fmt.Errorf("%w: invalid percentile range %v-%v", ErrInvalidRange, lowPct, highPct)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	start, end := percentileOffsets(lowPct, highPct, r.ranks.len())
+	return r.rankPage(start, end-start), nil
+}
+
+// CountInScoreRange 统计分数在[minScore, maxScore]内的玩家数，两端都包含
+func (r *RankingSystem) CountInScoreRange(minScore, maxScore int64) (_ int64, err error) {
+	defer r.opts.observeQuery("CountInScoreRange", time.Now(), &err)
+	if minScore > maxScore {
+		return 0, fmt.Errorf("%w: invalid score range %d-%d", ErrInvalidRange, minScore, maxScore)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lo, hi := r.opts.keyRange(minScore, maxScore)
+	return int64(r.ranks.countAtLeast(lo) - r.ranks.countAbove(hi)), nil
+}
+
+// ScoreHistogram 按分桶边界统计分数分布，边界需严格升序，返回len(buckets)+1个计数
+// 第i个计数（i从1开始）为分数在[buckets[i-1], buckets[i])内的人数，第0个为低于buckets[0]，最后一个为不低于最后一个边界
+// 在一次加锁内对每个边界做O(log n)的跳表查询，不遍历玩家
+func (r *RankingSystem) ScoreHistogram(buckets []int64) (_ []int64, err error) {
+	defer r.opts.observeQuery("ScoreHistogram", time.Now(), &err)
+	ranges, ok := histogramRanges(buckets)
+	if !ok {
+		return nil, fmt.Errorf("%w: bucket boundaries must be non-empty and strictly ascending", ErrInvalidRange)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make([]int64, len(ranges))
+	for i, rg := range ranges {
+		if rg[0] > rg[1] {
+			continue
 		}
-		return players[i].UpdateTime.Before(players[j].UpdateTime)
-	})
+		lo, hi := r.opts.keyRange(rg[0], rg[1])
+		counts[i] = int64(r.ranks.countAtLeast(lo) - r.ranks.countAbove(hi))
+	}
+	return counts, nil
+}
+
+// AverageScore 全榜平均分，排行榜为空时返回ErrNotEnoughPlayers
+func (r *RankingSystem) AverageScore() (_ float64, err error) {
+	defer r.opts.observeQuery("AverageScore", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.players) == 0 {
+		return 0, ErrNotEnoughPlayers
+	}
+	var sum float64
+	for _, p := range r.players {
+		sum += float64(r.opts.orient(p.Score))
+	}
+	return sum / float64(len(r.players)), nil
+}
+
+// MedianScore 全榜分数的中位数，人数为偶数时取中间两名的平均值，排行榜为空时返回ErrNotEnoughPlayers
+func (r *RankingSystem) MedianScore() (_ float64, err error) {
+	defer r.opts.observeQuery("MedianScore", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	total := r.ranks.len()
+	if total == 0 {
+		return 0, ErrNotEnoughPlayers
+	}
+	a := r.opts.orient(r.ranks.at((total - 1) / 2).Score)
+	b := r.opts.orient(r.ranks.at(total / 2).Score)
+	return (float64(a) + float64(b)) / 2, nil
+}
 
-	return players
+// GetPlayersInScoreRange 获取分数在[minScore, maxScore]内的玩家，按名次排列，最多limit名，名次为全榜名次
+func (r *RankingSystem) GetPlayersInScoreRange(minScore, maxScore int64, limit int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayersInScoreRange", time.Now(), &err)
+	if minScore > maxScore {
+		return nil, fmt.Errorf("%w: invalid score range %d-%d", ErrInvalidRange, minScore, maxScore)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", ErrInvalidRange)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lo, hi := r.opts.keyRange(minScore, maxScore)
+	start, end := r.ranks.countAbove(hi), r.ranks.countAtLeast(lo)
+	if start >= end {
+		return []PlayerRank{}, nil
+	}
+	return r.rankPage(start, min(limit, end-start)), nil
+}
+
+// CutoffScore 返回当前第n名玩家的分数，即进入前n名所需的最低分数
+// 人数不足n时返回ErrNotEnoughPlayers
+func (r *RankingSystem) CutoffScore(n int) (_ int64, err error) {
+	defer r.opts.observeQuery("CutoffScore", time.Now(), &err)
+	if n <= 0 {
+		return 0, ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if total := r.ranks.len(); n > total {
+		return 0, fmt.Errorf("%w: need %d, have %d", ErrNotEnoughPlayers, n, total)
+	}
+	return r.opts.orient(r.ranks.at(n - 1).Score), nil
+}
+
+// GetScoreRankForList 按输入顺序返回每个玩家的分数和名次
+// 结果与playerIDs逐位对应，不去重，同一ID出现两次会得到两行相同结果
+// 不存在的玩家对应行只填PlayerID，Rank为0，配置WithZeroBasedRanks时为-1
+func (r *RankingSystem) GetScoreRankForList(playerIDs []string) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetScoreRankForList", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]PlayerRank, 0, len(playerIDs))
+	for _, id := range playerIDs {
+		if p, ok := r.players[id]; ok {
+			result = append(result, r.opts.playerRank(p, r.rankOf(p)))
+		} else {
+			result = append(result, PlayerRank{PlayerID: id, Rank: r.opts.outRank(0)})
+		}
+	}
+
+	return result, nil
+}
+
+// GetRanks 批量查询玩家的分数和名次，不在榜的玩家不出现在结果中，名次与GetRank一致
+func (r *RankingSystem) GetRanks(playerIDs []string) (_ map[string]PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRanks", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]PlayerRank, len(playerIDs))
+	for _, id := range playerIDs {
+		if p, ok := r.players[id]; ok {
+			result[id] = r.opts.playerRank(p, r.rankOf(p))
+		}
+	}
+	return result, nil
+}
+
+// GetRankAmong 好友榜：把playerID和subset中的玩家按全榜规则排序，名次在这些玩家中重新从1计算
+// subset中不在榜的玩家跳过，重复ID只计一次；playerID总会包含在内，不在榜时返回ErrPlayerNotFound
+func (r *RankingSystem) GetRankAmong(playerID string, subset []string) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankAmong", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.players[playerID]; !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	seen := make(map[string]bool, len(subset)+1)
+	players := make([]*Player, 0, len(subset)+1)
+	for _, id := range append([]string{playerID}, subset...) {
+		p, exists := r.players[id]
+		if !exists || seen[id] {
+			continue
+		}
+		seen[id] = true
+		players = append(players, p)
+	}
+	sortPlayersBy(players, r.opts.rankBefore())
+
+	ranks := r.opts.sortedRanks(players)
+	result := make([]PlayerRank, 0, len(players))
+	for i, p := range players {
+		result = append(result, r.opts.playerRank(p, ranks[i]))
+	}
+	return result, nil
+}
+
+// GetTotalPlayers 获取总玩家数
+func (r *RankingSystem) GetTotalPlayers() (total int64, err error) {
+	defer r.opts.observeQuery("GetTotalPlayers", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.players)), nil
+}
+
+// PruneInactive 删除最后更新时间早于olderThan之前的玩家，返回删除人数
+func (r *RankingSystem) PruneInactive(olderThan time.Duration) (removed int, err error) {
+	defer r.opts.observeUpdate("PruneInactive", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := r.opts.now().Add(-olderThan)
+	for id, p := range r.players {
+		if p.UpdateTime.Before(cutoff) {
+			r.ranks.remove(p)
+			delete(r.players, id)
+			removed++
+		}
+	}
+	if removed > 0 {
+		r.version++
+	}
+	return removed, nil
+}
+
+// Clear 清空排行榜，用于赛季重置，排名基线保留
+// 在写锁内整体替换，并发读要么看到清空前的榜，要么看到空榜
+func (r *RankingSystem) Clear() (err error) {
+	defer r.opts.observeUpdate("Clear", time.Now(), &err)
+	return r.clear()
+}
+
+// clear 同Clear，不上报监控
+func (r *RankingSystem) clear() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.players = make(map[string]*Player)
+	r.ranks = r.opts.newRankList()
+	r.version++
+	return nil
+}
+
+// Reset 同Clear
+func (r *RankingSystem) Reset() (err error) {
+	defer r.opts.observeUpdate("Reset", time.Now(), &err)
+	return r.clear()
+}
+
+// RemovePlayer 移除玩家，可重复调用
+// wasPresent表示本次调用前玩家是否在榜，用于区分首次移除和重试
+func (r *RankingSystem) RemovePlayer(playerID string) (wasPresent bool, err error) {
+	defer r.opts.observeUpdate("RemovePlayer", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return false, nil
+	}
+
+	r.ranks.remove(player)
+	delete(r.players, playerID)
+	r.version++
+	return true, nil
+}
+
+// RemovePlayers 在一次加锁内批量移除玩家，removed为本次实际移除的人数，不在榜或重复的ID不计入
+func (r *RankingSystem) RemovePlayers(playerIDs []string) (removed int, err error) {
+	defer r.opts.observeUpdate("RemovePlayers", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, playerID := range playerIDs {
+		player, exists := r.players[playerID]
+		if !exists {
+			continue
+		}
+		r.ranks.remove(player)
+		delete(r.players, playerID)
+		removed++
+	}
+	if removed > 0 {
+		r.version++
+	}
+	return removed, nil
+}
+
+// IsEmpty 排行榜是否没有任何玩家
+func (r *RankingSystem) IsEmpty() (_ bool, err error) {
+	defer r.opts.observeQuery("IsEmpty", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.players) == 0, nil
+}
+
+// encodeBatch EncodeTopNJSON每批编码的玩家数
+const encodeBatch = 1000
+
+// EncodeTopNJSON 把前N名以JSON数组流式写入w，编码期间持有读锁，写入会等待编码完成
+func (r *RankingSystem) EncodeTopNJSON(w io.Writer, n int) (err error) {
+	defer r.opts.observeQuery("EncodeTopNJSON", time.Now(), &err)
+	if n <= 0 {
+		return ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	aw := newJSONArrayWriter(w)
+	for offset := 0; offset < n && offset < r.ranks.len(); offset += encodeBatch {
+		for _, pr := range r.rankPage(offset, min(encodeBatch, n-offset)) {
+			if err := aw.Write(pr); err != nil {
+				return err
+			}
+		}
+	}
+	return aw.Close()
+}
+
+// ExportTopNCSV 把前N名以CSV流式写入w，列为rank、playerID、score、updateTime，首行为表头
+// 名次和顺序与GetTopN完全一致，编码期间持有读锁；每批写完即刷新，不在内存中拼接整个文件
+func (r *RankingSystem) ExportTopNCSV(w io.Writer, n int) (err error) {
+	defer r.opts.observeQuery("ExportTopNCSV", time.Now(), &err)
+	if n <= 0 {
+		return ErrInvalidN
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for offset := 0; offset < n && offset < r.ranks.len(); offset += encodeBatch {
+		for _, pr := range r.rankPage(offset, min(encodeBatch, n-offset)) {
+			if err := writeCSVRow(cw, pr); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ScanAll 按名次从高到低遍历全榜，每次生成batchSize名的排名信息，batchSize<=0时每批1000名
+// fn返回false时停止；遍历期间持有读锁，写入会等待遍历结束，fn中不能调用排行榜的写方法
+func (r *RankingSystem) ScanAll(batchSize int, fn func(PlayerRank) bool) (err error) {
+	defer r.opts.observeQuery("ScanAll", time.Now(), &err)
+	if batchSize <= 0 {
+		batchSize = encodeBatch
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for offset := 0; offset < r.ranks.len(); offset += batchSize {
+		for _, pr := range r.rankPage(offset, batchSize) {
+			if !fn(pr) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// ExportJSON 把全榜按名次顺序以PlayerRecord的JSON数组写入w，用于备份和迁移
+func (r *RankingSystem) ExportJSON(w io.Writer) (err error) {
+	defer r.opts.observeQuery("ExportJSON", time.Now(), &err)
+	return r.exportJSON(w)
+}
+
+// exportJSON 同ExportJSON，不上报监控
+func (r *RankingSystem) exportJSON(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	aw := newJSONArrayWriter(w)
+	for _, p := range r.ranks.page(0, r.ranks.len()) {
+		rec := PlayerRecord{PlayerID: p.ID, Score: r.opts.orient(p.Score), Tiebreak: p.Tiebreak, UpdateTime: p.UpdateTime}
+		if err := aw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// ImportJSON 读取ExportJSON的输出并按原分数和更新时间恢复，同分先后与导出时一致
+// 分数不经过ScoreQuantizer；整个输入解析成功后才在一次加锁内写入，解析失败时排行榜不变
+func (r *RankingSystem) ImportJSON(rd io.Reader, mode ImportMode) (err error) {
+	defer r.opts.observeUpdate("ImportJSON", time.Now(), &err)
+	return r.importJSON(rd, mode)
+}
+
+// importJSON 同ImportJSON，不上报监控
+func (r *RankingSystem) importJSON(rd io.Reader, mode ImportMode) error {
+	records, err := readPlayerRecords(rd)
+	if err != nil {
+		return fmt.Errorf("decode import: %w", err)
+	}
+
+	players := make([]Player, len(records))
+	for i, rec := range records {
+		players[i] = Player{ID: rec.PlayerID, Score: rec.Score, Tiebreak: rec.Tiebreak, UpdateTime: rec.UpdateTime}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(players, mode)
+	return nil
+}
+
+// BulkLoad 在一次加锁内批量写入玩家，用于测试造数和数据迁移，比逐个UpdateScore少了逐次加锁和回调
+// 已有玩家保留，ID相同的被覆盖为传入的分数和更新时间，Meta为nil时保留原元数据；传入列表中ID重复时以最后一个为准
+// 分数不经过ScoreQuantizer；UpdateTime原样保存用于同分排序，为零值时取写入时间
+// 任一玩家ID为空时返回错误且不写入任何玩家
+func (r *RankingSystem) BulkLoad(players []Player) (err error) {
+	defer r.opts.observeUpdate("BulkLoad", time.Now(), &err)
+	now := r.opts.now()
+	batch := make([]Player, len(players))
+	for i, p := range players {
+		if p.ID == "" {
+			return fmt.Errorf("player ID must not be empty (index %d)", i)
+		}
+		if p.UpdateTime.IsZero() {
+			p.UpdateTime = now
+		}
+		batch[i] = p
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(batch, ImportMerge)
+	return nil
+}
+
+// load 按mode写入一批分数为原始分数的玩家，调用方需持有写锁
+func (r *RankingSystem) load(players []Player, mode ImportMode) {
+	if mode == ImportReplace {
+		r.players = make(map[string]*Player, len(players))
+		r.ranks = r.opts.newRankList()
+	}
+	for _, p := range players {
+		meta := p.Meta
+		if old, exists := r.players[p.ID]; exists {
+			if meta == nil {
+				meta = old.Meta
+			}
+			r.ranks.remove(old)
+			delete(r.players, p.ID)
+		}
+		r.addPlayer(&Player{
+			ID:         p.ID,
+			Score:      r.opts.orient(p.Score),
+			Tiebreak:   p.Tiebreak,
+			UpdateTime: p.UpdateTime,
+			Meta:       meta,
+		})
+	}
+	r.version++
+}
+
+// Merge 把other中的玩家合并到r，用于合并两个区服的排行榜，other不受影响
+// 只在other中的玩家原样加入；两边都有的玩家分数相加，更新时间取两者中较早的，次要排序值取较大的，元数据优先保留r中的
+// 按原始分数相加，两个排行榜的排名方向可以不同；配置了WithMaxSize时合并后裁剪多出的玩家
+func (r *RankingSystem) Merge(other *RankingSystem) {
+	var err error // Merge不会失败，只上报耗时
+	defer r.opts.observeUpdate("Merge", time.Now(), &err)
+	other.mu.RLock()
+	incoming := make([]Player, 0, len(other.players))
+	for _, p := range other.players {
+		cp := *p
+		cp.Score = other.opts.orient(p.Score)
+		incoming = append(incoming, cp)
+	}
+	other.mu.RUnlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range incoming {
+		p := &incoming[i]
+		old, exists := r.players[p.ID]
+		if !exists {
+			continue
+		}
+		p.Score += r.opts.orient(old.Score)
+		if old.UpdateTime.Before(p.UpdateTime) {
+			p.UpdateTime = old.UpdateTime
+		}
+		if old.Tiebreak > p.Tiebreak {
+			p.Tiebreak = old.Tiebreak
+		}
+		if old.Meta != nil {
+			p.Meta = old.Meta
+		}
+	}
+	r.load(incoming, ImportMerge)
+}
+
+// SaveToFile 以ExportJSON的格式把排行榜保存到path，先写临时文件再重命名，写入中途失败不会损坏原文件
+func (r *RankingSystem) SaveToFile(path string) (err error) {
+	defer r.opts.observeQuery("SaveToFile", time.Now(), &err)
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmp := f.Name()
+
+	if err := r.exportJSON(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadFromFile 读取SaveToFile保存的文件并整体替换当前排行榜，更新时间原样恢复，同分先后与保存时一致
+// 文件解析成功后才在一次加锁内替换，失败时排行榜不变
+func (r *RankingSystem) LoadFromFile(path string) (err error) {
+	defer r.opts.observeUpdate("LoadFromFile", time.Now(), &err)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := r.importJSON(f, ImportReplace); err != nil {
+		return fmt.Errorf("load %s: %w", path, err)
+	}
+	return nil
+}
+
+// MarshalBinary 以gob编码全部玩家的ID、原始分数、次要排序值、更新时间和元数据，比ExportJSON紧凑，用于定期快照
+// 更新时间保留纳秒精度，UnmarshalBinary后同分先后不变；排名基线和名次快照不包含在内
+func (r *RankingSystem) MarshalBinary() (_ []byte, err error) {
+	defer r.opts.observeQuery("MarshalBinary", time.Now(), &err)
+	r.mu.RLock()
+	players := make([]Player, 0, r.ranks.len())
+	for _, p := range r.ranks.page(0, r.ranks.len()) {
+		cp := *p
+		cp.Score = r.opts.orient(p.Score)
+		players = append(players, cp)
+	}
+	r.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(players); err != nil {
+		return nil, fmt.Errorf("encode snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary 读取MarshalBinary的输出并整体替换当前排行榜，按当前配置重建排名
+// 解码成功后才在一次加锁内替换，失败时排行榜不变；应在NewRankingSystem创建的实例上调用
+func (r *RankingSystem) UnmarshalBinary(data []byte) (err error) {
+	defer r.opts.observeUpdate("UnmarshalBinary", time.Now(), &err)
+	var players []Player
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&players); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.load(players, ImportReplace)
+	return nil
+}
+
+// CaptureBaseline 以name保存当前全榜名次作为基线，同名基线会被覆盖
+func (r *RankingSystem) CaptureBaseline(name string) (err error) {
+	defer r.opts.observeUpdate("CaptureBaseline", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.baselines[name] = r.captureBaseline()
+	return nil
+}
+
+// captureBaseline 当前全榜名次，调用方需持有锁
+func (r *RankingSystem) captureBaseline() *baseline {
+	b := &baseline{
+		ranks: make(map[string]int, r.ranks.len()),
+		total: r.ranks.len(),
+	}
+	for _, pr := range r.rankPage(0, r.ranks.len()) {
+		b.ranks[pr.PlayerID] = pr.Rank
+	}
+	return b
+}
+
+// RankChangeSinceBaseline 返回玩家相对基线上升的名次数，正数表示上升，负数表示下降
+// 基线中没有的玩家视为新上榜，按基线总人数+1名计算
+func (r *RankingSystem) RankChangeSinceBaseline(name, playerID string) (delta int, err error) {
+	defer r.opts.observeQuery("RankChangeSinceBaseline", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.baselines[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrBaselineNotFound, name)
+	}
+	return r.rankChange(b, playerID)
+}
+
+// rankChange 玩家相对b上升的名次数，调用方需持有读锁
+func (r *RankingSystem) rankChange(b *baseline, playerID string) (int, error) {
+	player, exists := r.players[playerID]
+	if !exists {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	// 基线中保存的是返回给调用方的名次
+	oldRank, ok := b.ranks[playerID]
+	if !ok {
+		oldRank = r.opts.outRank(b.total + 1)
+	}
+	return oldRank - r.opts.outRank(r.rankOf(player)), nil
+}
+
+// snapshotName 名次快照在baselines中的名称，带\x00前缀避免与调用方的基线重名
+func snapshotName(snapshotID string) string {
+	return "\x00snapshot:" + snapshotID
+}
+
+// SnapshotRanks 保存当前全榜名次，返回用于RankDelta的快照ID，用于“比昨天上升了5名”这类展示
+// 快照会一直保留，不再需要时调用DeleteSnapshot释放
+func (r *RankingSystem) SnapshotRanks() (snapshotID string, err error) {
+	defer r.opts.observeUpdate("SnapshotRanks", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.snapshotSeq++
+	snapshotID = strconv.FormatUint(r.snapshotSeq, 10)
+	r.baselines[snapshotName(snapshotID)] = r.captureBaseline()
+	return snapshotID, nil
+}
+
+// RankDelta 返回玩家当前名次减去快照中的名次，负数表示名次上升
+// 快照中没有的玩家视为新上榜，按快照总人数+1名计算；快照不存在时返回ErrSnapshotNotFound
+func (r *RankingSystem) RankDelta(playerID, snapshotID string) (delta int, err error) {
+	defer r.opts.observeQuery("RankDelta", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.baselines[snapshotName(snapshotID)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotID)
+	}
+	change, err := r.rankChange(b, playerID)
+	return -change, err
+}
+
+// DeleteSnapshot 删除名次快照，快照不存在时不视为错误
+func (r *RankingSystem) DeleteSnapshot(snapshotID string) (err error) {
+	defer r.opts.observeUpdate("DeleteSnapshot", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.baselines, snapshotName(snapshotID))
+	return nil
+}
+
+// Clone 在读锁下深拷贝当前排行榜，返回独立的实例
+// 副本与原排行榜互不影响，适合分析任务在不阻塞写入的情况下遍历
+func (r *RankingSystem) Clone() *RankingSystem {
+	var err error
+	defer r.opts.observeQuery("Clone", time.Now(), &err)
+	return r.clone()
+}
+
+// clone 同Clone，不上报监控
+func (r *RankingSystem) clone() *RankingSystem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c := &RankingSystem{
+		players:     make(map[string]*Player, len(r.players)),
+		ranks:       r.opts.newRankList(),
+		opts:        r.opts,
+		baselines:   make(map[string]*baseline, len(r.baselines)),
+		snapshotSeq: r.snapshotSeq,
+	}
+	for name, b := range r.baselines {
+		c.baselines[name] = b // 基线创建后只读，可以共享
+	}
+	for _, p := range r.ranks.page(0, r.ranks.len()) {
+		cp := *p
+		c.players[cp.ID] = &cp
+		c.ranks.insert(&cp)
+	}
+
+	return c
+}
+
+// Snapshot 同Clone，用于赛季结束时在内存中保留上一赛季的独立副本
+func (r *RankingSystem) Snapshot() *RankingSystem {
+	var err error
+	defer r.opts.observeQuery("Snapshot", time.Now(), &err)
+	return r.clone()
+}
+
+// sortPlayersBy 按before排序
+func sortPlayersBy(players []*Player, before func(a, b *Player) bool) {
+	sort.Slice(players, func(i, j int) bool {
+		return before(players[i], players[j])
+	})
+}
+
+// competitionRanks 计算已排序玩家的名次，同分并列取并列中的第一名，下一个分数的名次跳过并列人数
+func competitionRanks(players []*Player) []int {
+	ranks := make([]int, len(players))
+	for i, p := range players {
+		if i > 0 && p.Score == players[i-1].Score {
+			ranks[i] = ranks[i-1]
+		} else {
+			ranks[i] = i + 1
+		}
+	}
+	return ranks
+}
+
+// rankBefore a是否排在b前面
+// 先按分数降序，分数相同则按次要排序值降序，再相同按更新时间升序（先达到该分数的排前面），
+// 时间只比较到秒，同一秒再按玩家ID字典序降序，与Redis复合分数及ZREVRANGE的顺序一致
+func rankBefore(a, b *Player) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Tiebreak != b.Tiebreak {
+		return a.Tiebreak > b.Tiebreak
+	}
+	if ta, tb := a.UpdateTime.Unix(), b.UpdateTime.Unix(); ta != tb {
+		return ta < tb
+	}
+	return a.ID > b.ID
+}
+
+// rankBeforeLatest 同rankBefore，但同分时更新时间越晚越靠前
+func rankBeforeLatest(a, b *Player) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Tiebreak != b.Tiebreak {
+		return a.Tiebreak > b.Tiebreak
+	}
+	if ta, tb := a.UpdateTime.Unix(), b.UpdateTime.Unix(); ta != tb {
+		return ta > tb
+	}
+	return a.ID > b.ID
+}
+
+// rankWindow 下标为index的玩家前后共n名的窗口[start, end)，玩家前面取(n-1)/2名，
+// 碰到榜首或榜尾时向另一侧平移，窗口大小为min(n, total)
+func rankWindow(index, total, n int) (start, end int) {
+	start = max(0, index-(n-1)/2)
+	end = min(total, start+n)
+	if end-start < n {
+		start = max(0, end-n)
+	}
+	return start, end
+}
+
+// percentileOffsets 把百分位区间换算成[start, end)下标，加一个极小量避免浮点误差少算一名
+func percentileOffsets(lowPct, highPct float64, total int) (start, end int) {
+	start = int(math.Floor(lowPct*float64(total) + 1e-9))
+	end = int(math.Floor(highPct*float64(total) + 1e-9))
+	return start, end
+}
+
+// histogramRanges 把升序的分桶边界换算成len(buckets)+1个闭区间，第一个为低于buckets[0]，最后一个为不低于最后一个边界
+// 边界为空或不是严格升序时ok为false；区间为空时lo>hi
+func histogramRanges(buckets []int64) (ranges [][2]int64, ok bool) {
+	if len(buckets) == 0 {
+		return nil, false
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return nil, false
+		}
+	}
+
+	ranges = make([][2]int64, 0, len(buckets)+1)
+	if buckets[0] == math.MinInt64 {
+		ranges = append(ranges, [2]int64{1, 0})
+	} else {
+		ranges = append(ranges, [2]int64{math.MinInt64, buckets[0] - 1})
+	}
+	for i := 1; i < len(buckets); i++ {
+		ranges = append(ranges, [2]int64{buckets[i-1], buckets[i] - 1})
+	}
+	ranges = append(ranges, [2]int64{buckets[len(buckets)-1], math.MaxInt64})
+	return ranges, true
 }
 
 // 辅助函数
@@ -0,0 +1,385 @@
+package game_rank_test
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLargeBoard 创建有n名玩家的内存排行榜
+func newLargeBoard(t testing.TB, n int, opts ...Option) *RankingSystem {
+	t.Helper()
+	players := make([]Player, n)
+	for i := range players {
+		players[i] = Player{ID: "p" + strconv.Itoa(i), Score: int64(i % 5000)}
+	}
+	r := NewRankingSystem(opts...)
+	if err := r.BulkLoad(players); err != nil {
+		t.Fatalf("BulkLoad: %v", err)
+	}
+	return r
+}
+
+// TestUpdateScoreLargeBoard 大榜单上UpdateScore不因重新排序阻塞，并发读取始终可用
+func TestUpdateScoreLargeBoard(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large board")
+	}
+	r := newLargeBoard(t, 200000)
+
+	stop, started := make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for reads := 0; ; reads++ {
+			if reads == 1 {
+				close(started)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := r.GetTopN(10); err != nil {
+				t.Errorf("GetTopN: %v", err)
+				return
+			}
+			if _, _, err := r.GetRank("p42"); err != nil {
+				t.Errorf("GetRank: %v", err)
+				return
+			}
+		}
+	}()
+	<-started
+
+	durations := make([]time.Duration, 2000)
+	for i := range durations {
+		start := time.Now()
+		if err := r.UpdateScore("p"+strconv.Itoa(i*97%200000), int64(i)); err != nil {
+			t.Fatalf("UpdateScore: %v", err)
+		}
+		durations[i] = time.Since(start)
+	}
+	close(stop)
+	wg.Wait()
+
+	// 整榜重新排序需要数十毫秒，增量维护的写入远低于该值；取99分位，避开GC停顿造成的个别慢调用
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	if p99 := durations[len(durations)*99/100]; p99 > 5*time.Millisecond {
+		t.Errorf("p99 UpdateScore took %v on a 200000-player board", p99)
+	}
+}
+
+// TestRankChangeNewPlayerTrimmed 新玩家写入后随即被WithMaxSize裁剪时不回调，之后以足够高的分数上榜时按新上榜回调
+func TestRankChangeNewPlayerTrimmed(t *testing.T) {
+	clock := newTestClock()
+	r := NewRankingSystem(WithClock(clock.now), WithMaxSize(2))
+	if err := r.UpdateScores(map[string]int64{"a": 30, "b": 20}); err != nil {
+		t.Fatal(err)
+	}
+	changes := recordRankChanges(r)
+
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"c", 1}, {"c", 100}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []rankChange{{"c", 0, 1}}; !reflect.DeepEqual(*changes, want) {
+		t.Errorf("rank changes = %v, want %v", *changes, want)
+	}
+}
+
+// TestRankChangeAllWrites 两种排行榜的UpdateScores、IncrementScore和UpdateScoreIfHigher同样报告被更新玩家的名次变化，取消注册后不再回调
+func TestRankChangeAllWrites(t *testing.T) {
+	type notifyRanker interface {
+		Ranker
+		OnRankChange(fn RankChangeFunc)
+		UpdateScores(updates map[string]int64) error
+		IncrementScore(playerID string, delta int64) (int64, error)
+		UpdateScoreIfHigher(playerID string, score int64) (bool, error)
+	}
+	memClock, redisClock := newTestClock(), newTestClock()
+	rds, _ := newTestRedis(t, WithClock(redisClock.now))
+	boards := []struct {
+		r     notifyRanker
+		clock *testClock
+	}{{NewRankingSystem(WithClock(memClock.now)), memClock}, {rds, redisClock}}
+	for _, b := range boards {
+		r, clock := b.r, b.clock
+		changes := recordRankChanges(r)
+
+		if err := r.UpdateScores(map[string]int64{"a": 10, "b": 20}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(*changes, func(i, j int) bool { return (*changes)[i].playerID < (*changes)[j].playerID })
+		if want := []rankChange{{"a", 0, 2}, {"b", 0, 1}}; !reflect.DeepEqual(*changes, want) {
+			t.Fatalf("%T UpdateScores rank changes = %v, want %v", r, *changes, want)
+		}
+
+		*changes = nil
+		clock.advance(time.Second)
+		if _, err := r.IncrementScore("a", 15); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Second)
+		if _, err := r.UpdateScoreIfHigher("b", 5); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Second)
+		if _, err := r.UpdateScoreIfHigher("b", 30); err != nil {
+			t.Fatal(err)
+		}
+		if want := []rankChange{{"a", 2, 1}, {"b", 2, 1}}; !reflect.DeepEqual(*changes, want) {
+			t.Errorf("%T rank changes = %v, want %v", r, *changes, want)
+		}
+
+		// 取消注册后不再回调
+		*changes = nil
+		r.OnRankChange(nil)
+		if _, err := r.IncrementScore("a", 100); err != nil {
+			t.Fatal(err)
+		}
+		if len(*changes) != 0 {
+			t.Errorf("%T rank changes after unregistering = %v", r, *changes)
+		}
+	}
+}
+
+// TestPlayerRankRangeInsideTie 窗口从三人并列的第二人开始时，名次仍按全榜并列计算，与GetRank和GetTopN一致
+func TestPlayerRankRangeInsideTie(t *testing.T) {
+	cases := []struct {
+		mode RankMode
+		want []int
+	}{
+		{StandardRanking, []int{2, 2, 5}},
+		{DenseRanking, []int{2, 2, 3}},
+	}
+	for _, tc := range cases {
+		memClock, redisClock := newTestClock(), newTestClock()
+		rds, _ := newTestRedis(t, WithClock(redisClock.now), WithRankMode(tc.mode))
+		boards := []struct {
+			r     Ranker
+			clock *testClock
+		}{{NewRankingSystem(WithClock(memClock.now), WithRankMode(tc.mode)), memClock}, {rds, redisClock}}
+		for _, b := range boards {
+			for _, s := range []struct {
+				id    string
+				score int64
+			}{{"top", 50}, {"a", 30}, {"b", 30}, {"c", 30}, {"d", 10}} {
+				b.clock.advance(time.Second)
+				if err := b.r.UpdateScore(s.id, s.score); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// c前面取1名，窗口为b、c、d
+			window, err := b.r.GetPlayerRankRange("c", 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			top, err := b.r.GetTopN(5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(window) != 3 {
+				t.Fatalf("%T mode=%v window = %+v", b.r, tc.mode, window)
+			}
+			for i, pr := range window {
+				if pr.PlayerID != []string{"b", "c", "d"}[i] || pr.Rank != tc.want[i] || pr.Position != i+1 {
+					t.Errorf("%T mode=%v window[%d] = %+v, want rank %d", b.r, tc.mode, i, pr, tc.want[i])
+				}
+				if rank, _, err := b.r.GetRank(pr.PlayerID); err != nil || rank != pr.Rank {
+					t.Errorf("%T mode=%v GetRank(%s) = %d, %v, window rank %d", b.r, tc.mode, pr.PlayerID, rank, err, pr.Rank)
+				}
+				if top[i+2].PlayerID != pr.PlayerID || top[i+2].Rank != pr.Rank {
+					t.Errorf("%T mode=%v GetTopN[%d] = %+v, window %+v", b.r, tc.mode, i+2, top[i+2], pr)
+				}
+			}
+		}
+	}
+}
+
+// TestTiePolicy a先达到100分，b随后同分；a再次提交100分后，KeepFirst下a仍在前，RefreshOnResubmit下a排到b之后
+func TestTiePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy TiePolicy
+		want   []string
+	}{
+		{KeepFirst, []string{"a", "b"}},
+		{RefreshOnResubmit, []string{"b", "a"}},
+	} {
+		clock := newTestClock()
+		r := NewRankingSystem(WithClock(clock.now), WithTiePolicy(tc.policy))
+		for _, id := range []string{"a", "b", "a", "a"} {
+			clock.advance(time.Second)
+			if err := r.UpdateScore(id, 100); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := topIDs(t, r, 2); !equalIDs(got, tc.want) {
+			t.Errorf("policy=%v top = %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
+
+// TestCloneIndependent 修改副本的分数、移除和新增玩家都不影响原排行榜
+func TestCloneIndependent(t *testing.T) {
+	r := NewRankingSystem()
+	for id, score := range map[string]int64{"a": 30, "b": 20, "c": 10} {
+		if err := r.UpdateScore(id, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := r.Clone()
+	if got := topIDs(t, c, 3); !equalIDs(got, []string{"a", "b", "c"}) {
+		t.Fatalf("clone top = %v, want [a b c]", got)
+	}
+	if err := c.UpdateScore("c", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RemovePlayer("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateScore("d", 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := topIDs(t, c, 4); !equalIDs(got, []string{"c", "b", "d"}) {
+		t.Errorf("clone top after writes = %v, want [c b d]", got)
+	}
+
+	if got := topIDs(t, r, 4); !equalIDs(got, []string{"a", "b", "c"}) {
+		t.Errorf("original top = %v, want [a b c]", got)
+	}
+	if score, err := r.GetScore("c"); err != nil || score != 10 {
+		t.Errorf("original GetScore(c) = %d, %v, want 10", score, err)
+	}
+}
+
+// TestPlayerRankRangeWrap 榜首玩家的窗口前面从榜尾补齐，榜尾玩家的窗口后面从榜首补齐，补进来的玩家保留全榜名次
+func TestPlayerRankRangeWrap(t *testing.T) {
+	r := NewRankingSystem()
+	for i := 1; i <= 10; i++ {
+		if err := r.UpdateScore("p"+strconv.Itoa(i), int64(100-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		player string
+		n      int
+		want   []int
+	}{
+		{"p1", 5, []int{9, 10, 1, 2, 3}},
+		{"p10", 4, []int{9, 10, 1, 2}},
+		{"p5", 20, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+	} {
+		window, err := r.GetPlayerRankRangeWrap(tc.player, tc.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranks := make([]int, 0, len(window))
+		for i, pr := range window {
+			ranks = append(ranks, pr.Rank)
+			if pr.PlayerID != "p"+strconv.Itoa(pr.Rank) || pr.Position != i+1 {
+				t.Errorf("GetPlayerRankRangeWrap(%s, %d)[%d] = %+v", tc.player, tc.n, i, pr)
+			}
+		}
+		if !reflect.DeepEqual(ranks, tc.want) {
+			t.Errorf("GetPlayerRankRangeWrap(%s, %d) ranks = %v, want %v", tc.player, tc.n, ranks, tc.want)
+		}
+	}
+}
+
+// TestRemovePlayerUpdatesRanks 移除玩家后跳表同步更新，GetTopN不再包含该玩家，其余玩家的名次前移
+func TestRemovePlayerUpdatesRanks(t *testing.T) {
+	r := NewRankingSystem()
+	for id, score := range map[string]int64{"a": 40, "b": 30, "c": 20, "d": 10} {
+		if err := r.UpdateScore(id, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.RemovePlayer("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := r.GetTopN(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PlayerRank{{PlayerID: "a", Score: 40, Rank: 1}, {PlayerID: "c", Score: 20, Rank: 2}, {PlayerID: "d", Score: 10, Rank: 3}}
+	if len(top) != len(want) {
+		t.Fatalf("GetTopN after removal = %+v", top)
+	}
+	for i, w := range want {
+		if top[i].PlayerID != w.PlayerID || top[i].Score != w.Score || top[i].Rank != w.Rank {
+			t.Errorf("top[%d] = %+v, want %+v", i, top[i], w)
+		}
+	}
+	if rank, _, err := r.GetRank("d"); err != nil || rank != 3 {
+		t.Errorf("GetRank(d) = %d, %v, want 3", rank, err)
+	}
+	if total, err := r.GetTotalPlayers(); err != nil || total != 3 {
+		t.Errorf("GetTotalPlayers = %d, %v, want 3", total, err)
+	}
+
+	// 重新上榜的玩家按新分数排名
+	if err := r.UpdateScore("b", 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := topIDs(t, r, 10); !equalIDs(got, []string{"a", "c", "d", "b"}) {
+		t.Errorf("top after re-adding b = %v, want [a c d b]", got)
+	}
+}
+
+// BenchmarkLargeBoardReads 十万人榜单上的读取直接取自跳表，开销与一次整榜排序（改动前GetPlayerRankRange的做法）相比
+func BenchmarkLargeBoardReads(b *testing.B) {
+	const players = 100000
+	r := newLargeBoard(b, players)
+	ids := make([]string, players)
+	for i := range ids {
+		ids[i] = "p" + strconv.Itoa(i)
+	}
+
+	b.Run("GetPlayerRankRange", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.GetPlayerRankRange(ids[i*7919%players], 11); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetRank", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := r.GetRank(ids[i*7919%players]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetRankPage", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := r.GetRankPage(i*7919%players, 20); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("SortBaseline", func(b *testing.B) {
+		before := r.opts.rankBefore()
+		sorted := make([]*Player, 0, players)
+		for i := 0; i < b.N; i++ {
+			sorted = sorted[:0]
+			r.mu.RLock()
+			for _, p := range r.players {
+				sorted = append(sorted, p)
+			}
+			r.mu.RUnlock()
+			sortPlayersBy(sorted, before)
+		}
+	})
+}
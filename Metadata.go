@@ -0,0 +1,138 @@
+package game_rank_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PlayerMeta 随分数一起展示的玩家元数据，如昵称、头像ID、公会标签，应保持很小
+type PlayerMeta map[string]string
+
+// clone 复制元数据，空元数据返回nil
+func (m PlayerMeta) clone() PlayerMeta {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(PlayerMeta, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// keys 按字典序排列的元数据键
+func (m PlayerMeta) keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetMetadata 整体替换玩家的元数据，不影响分数、更新时间和名次；meta为空时清除
+// 玩家不在榜时返回ErrPlayerNotFound，元数据随玩家移除一起删除
+func (r *RankingSystem) SetMetadata(playerID string, meta PlayerMeta) (err error) {
+	defer r.opts.observeUpdate("SetMetadata", time.Now(), &err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	player.Meta = meta.clone()
+	// 前N名结果包含元数据，ETag需随之变化
+	r.version++
+	return nil
+}
+
+// GetMetadata 获取玩家的元数据，未设置时返回nil，玩家不在榜时返回ErrPlayerNotFound
+func (r *RankingSystem) GetMetadata(playerID string) (_ PlayerMeta, err error) {
+	defer r.opts.observeQuery("GetMetadata", time.Now(), &err)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	player, exists := r.players[playerID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	return player.Meta.clone(), nil
+}
+
+// metaKey 玩家元数据哈希，字段为玩家ID，值为元数据的JSON
+func (r *RedisRankingList) metaKey() string {
+	return r.key + ":meta"
+}
+
+// SetMetadata 整体替换玩家的元数据，不影响分数和名次；meta为空时清除
+// 元数据单独存放，可在玩家上榜前设置；硬删除玩家和Clear时一并删除
+func (r *RedisRankingList) SetMetadata(playerID string, meta PlayerMeta) (err error) {
+	defer r.opts.observeUpdate("SetMetadata", time.Now(), &err)
+	if len(meta) == 0 {
+		if err := r.client.HDel(r.ctx, r.metaKey(), playerID).Err(); err != nil {
+			return fmt.Errorf("清除元数据失败: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("编码元数据失败: %w", err)
+	}
+	if err := r.client.HSet(r.ctx, r.metaKey(), playerID, data).Err(); err != nil {
+		return fmt.Errorf("设置元数据失败: %w", err)
+	}
+	return nil
+}
+
+// GetMetadata 获取玩家的元数据，未设置时返回nil
+func (r *RedisRankingList) GetMetadata(playerID string) (_ PlayerMeta, err error) {
+	defer r.opts.observeQuery("GetMetadata", time.Now(), &err)
+	data, err := r.client.HGet(r.ctx, r.metaKey(), playerID).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取元数据失败: %w", err)
+	}
+
+	var meta PlayerMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("解析元数据失败: %w", err)
+	}
+	return meta, nil
+}
+
+// attachMeta 配置了WithMetadata时用一条HMGET为名次列表填入元数据
+func (r *RedisRankingList) attachMeta(rankings []PlayerRank) ([]PlayerRank, error) {
+	if !r.opts.withMetadata || len(rankings) == 0 {
+		return rankings, nil
+	}
+
+	ids := make([]string, len(rankings))
+	for i, pr := range rankings {
+		ids[i] = pr.PlayerID
+	}
+	values, err := r.client.HMGet(r.ctx, r.metaKey(), ids...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取元数据失败: %w", err)
+	}
+
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var meta PlayerMeta
+		if err := json.Unmarshal([]byte(data), &meta); err != nil {
+			return nil, fmt.Errorf("解析玩家%s的元数据失败: %w", rankings[i].PlayerID, err)
+		}
+		rankings[i].Meta = meta
+	}
+	return rankings, nil
+}
@@ -0,0 +1,77 @@
+package game_rank_test
+
+import "time"
+
+// MetricsObserver 排行榜操作的监控回调，由调用方实现并通过WithMetricsObserver注册，包本身不依赖任何监控库
+// op为方法名，如"UpdateScore"、"GetTopN"；duration为整个调用的耗时，Redis排行榜包含重试和等待
+// 每次调用都会触发ObserveUpdate或ObserveQuery，返回错误时再额外触发ObserveError，
+// 玩家不存在等业务错误也会上报，需要区分时用errors.Is判断；回调在调用方的goroutine中同步执行，应尽快返回
+//
+// 接入Prometheus时，可以用一个按op和kind区分的HistogramVec记录耗时，一个按op区分的CounterVec记录错误：
+//
+//	type promObserver struct {
+//		latency *prometheus.HistogramVec // 标签op、kind
+//		errors  *prometheus.CounterVec   // 标签op
+//	}
+//
+//	func (p promObserver) ObserveUpdate(op string, d time.Duration) {
+//		p.latency.WithLabelValues(op, "update").Observe(d.Seconds())
+//	}
+//
+//	func (p promObserver) ObserveQuery(op string, d time.Duration) {
+//		p.latency.WithLabelValues(op, "query").Observe(d.Seconds())
+//	}
+//
+//	func (p promObserver) ObserveError(op string, err error) {
+//		p.errors.WithLabelValues(op).Inc()
+//	}
+//
+// 两个指标用prometheus.MustRegister注册后，以NewRedisRankingWithClient(client, key, WithMetricsObserver(promObserver{...}))创建排行榜；
+// 调用次数即直方图的_count，无需单独计数
+type MetricsObserver interface {
+	// ObserveUpdate 写操作完成
+	ObserveUpdate(op string, duration time.Duration)
+	// ObserveQuery 读操作完成
+	ObserveQuery(op string, duration time.Duration)
+	// ObserveError 操作返回了错误
+	ObserveError(op string, err error)
+}
+
+// noopObserver 未配置WithMetricsObserver时使用，不做任何事
+type noopObserver struct{}
+
+func (noopObserver) ObserveUpdate(string, time.Duration) {}
+func (noopObserver) ObserveQuery(string, time.Duration)  {}
+func (noopObserver) ObserveError(string, error)          {}
+
+// WithMetricsObserver 注册监控回调，传nil表示不监控
+// 内存和Redis排行榜除OnRankChange、Close和GetRealScore外的公开方法都会上报，op为方法名：
+// 改变榜单、元数据、排名基线或名次快照的方法（UpdateScore、EnsurePlayer、Clear、ImportJSON、CaptureBaseline等）触发ObserveUpdate，其余触发ObserveQuery；
+// 方法内部复用其他公开方法的逻辑时只按外层方法名上报一次，带Ctx后缀的方法按去掉后缀的方法名上报；
+// ShardedRankingSystem只有Ranker接口的方法，分片内部不重复上报；BufferedRankingList、HybridRanker等包装类型
+// 不单独上报，由其调用的排行榜按被调用的方法名上报；ScanAll、ForEach等带回调的方法耗时包含回调本身
+// 耗时按真实时间计算，不受WithClock影响
+func WithMetricsObserver(m MetricsObserver) Option {
+	return func(o *options) {
+		if m == nil {
+			m = noopObserver{}
+		}
+		o.metrics = m
+	}
+}
+
+// observeUpdate 上报写操作op自start起的耗时，*err非nil时同时上报错误，用法为defer r.opts.observeUpdate(op, time.Now(), &err)
+func (o *options) observeUpdate(op string, start time.Time, err *error) {
+	o.metrics.ObserveUpdate(op, time.Since(start))
+	if *err != nil {
+		o.metrics.ObserveError(op, *err)
+	}
+}
+
+// observeQuery 同observeUpdate，用于读操作
+func (o *options) observeQuery(op string, start time.Time, err *error) {
+	o.metrics.ObserveQuery(op, time.Since(start))
+	if *err != nil {
+		o.metrics.ObserveError(op, *err)
+	}
+}
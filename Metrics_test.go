@@ -0,0 +1,92 @@
+package game_rank_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver 按顺序记录上报的操作
+type recordingObserver struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (o *recordingObserver) ObserveUpdate(op string, _ time.Duration) { o.record("update:" + op) }
+func (o *recordingObserver) ObserveQuery(op string, _ time.Duration)  { o.record("query:" + op) }
+func (o *recordingObserver) ObserveError(string, error)               {}
+
+func (o *recordingObserver) record(op string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ops = append(o.ops, op)
+}
+
+// take 取出并清空已记录的操作
+func (o *recordingObserver) take() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ops := o.ops
+	o.ops = nil
+	return ops
+}
+
+// TestMetricsObserverCoverage 接口之外的公开方法同样上报，内部复用其他公开方法时只按外层方法名上报一次
+func TestMetricsObserverCoverage(t *testing.T) {
+	memObs, redisObs := &recordingObserver{}, &recordingObserver{}
+	rds, _ := newTestRedis(t, WithMetricsObserver(redisObs))
+	boards := []struct {
+		r   Ranker
+		obs *recordingObserver
+	}{{NewRankingSystem(WithMetricsObserver(memObs)), memObs}, {rds, redisObs}}
+
+	type extras interface {
+		UpdateScoreIfHigher(playerID string, score int64) (bool, error)
+		EnsurePlayer(playerID string) (bool, error)
+		GetRankDetail(playerID string) (int, int64, int64, error)
+		GetTopNApprox(n int) ([]PlayerRank, error)
+		GetTopNIfChanged(n int, etag string) ([]PlayerRank, string, bool, error)
+		GetRankRange(startRank, endRank int) ([]PlayerRank, error)
+		GetPlayerRankRangeStrict(playerID string, n int) ([]PlayerRank, error)
+		GetBottomN(n int) ([]PlayerRank, error)
+		GetScoreRankForList(playerIDs []string) ([]PlayerRank, error)
+		GetPercentile(playerID string) (float64, error)
+		CountInScoreRange(minScore, maxScore int64) (int64, error)
+		RankRangeByPercentile(lowPct, highPct float64) ([]PlayerRank, error)
+		SetMetadata(playerID string, meta PlayerMeta) error
+		Reset() error
+	}
+	for _, b := range boards {
+		r := b.r.(extras)
+		steps := []struct {
+			op   string
+			call func() error
+		}{
+			{"update:UpdateScore", func() error { return b.r.UpdateScore("a", 10) }},
+			{"update:UpdateScoreIfHigher", func() error { _, err := r.UpdateScoreIfHigher("b", 20); return err }},
+			{"update:EnsurePlayer", func() error { _, err := r.EnsurePlayer("c"); return err }},
+			{"update:SetMetadata", func() error { return r.SetMetadata("a", PlayerMeta{"name": "A"}) }},
+			{"query:GetRankDetail", func() error { _, _, _, err := r.GetRankDetail("a"); return err }},
+			{"query:GetTopNApprox", func() error { _, err := r.GetTopNApprox(3); return err }},
+			{"query:GetTopNIfChanged", func() error { _, _, _, err := r.GetTopNIfChanged(3, ""); return err }},
+			{"query:GetRankRange", func() error { _, err := r.GetRankRange(1, 2); return err }},
+			{"query:GetPlayerRankRange", func() error { _, err := b.r.GetPlayerRankRange("a", 3); return err }},
+			{"query:GetPlayerRankRangeStrict", func() error { _, err := r.GetPlayerRankRangeStrict("a", 3); return err }},
+			{"query:GetBottomN", func() error { _, err := r.GetBottomN(2); return err }},
+			{"query:GetScoreRankForList", func() error { _, err := r.GetScoreRankForList([]string{"a", "x"}); return err }},
+			{"query:GetPercentile", func() error { _, err := r.GetPercentile("a"); return err }},
+			{"query:CountInScoreRange", func() error { _, err := r.CountInScoreRange(0, 100); return err }},
+			{"query:RankRangeByPercentile", func() error { _, err := r.RankRangeByPercentile(0, 0.5); return err }},
+			{"update:Reset", r.Reset},
+		}
+		for _, s := range steps {
+			if err := s.call(); err != nil {
+				t.Fatalf("%T %s: %v", b.r, s.op, err)
+			}
+			if got := b.obs.take(); !reflect.DeepEqual(got, []string{s.op}) {
+				t.Errorf("%T reported %v, want [%s]", b.r, got, s.op)
+			}
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package game_rank_test
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultRankRangeSize GetPlayerRankRange未指定n时默认返回的玩家数
+const DefaultRankRangeSize = 10
+
+// Option 排行榜配置选项
+type Option func(*options)
+
+// options 排行榜配置
+type options struct {
+	quantizer         ScoreQuantizer   // 写入前的分数量化，nil表示不量化
+	tiePolicy         TiePolicy        // 同分重复提交时的更新时间策略
+	defaultWindow     int              // GetPlayerRankRange的n<=0时使用的窗口大小
+	avoidCollision    bool             // Redis写入时避开与其他玩家相同的复合分数
+	maxSize           int              // 排行榜最多保留的人数，0表示不限制
+	trimEvery         int              // 每多少次写入裁剪一次
+	tombstoneGrace    time.Duration    // 软删除的保留时长，0表示RemovePlayer直接删除
+	scoreBits         int              // Redis复合分数中真实分数占的位数
+	order             Order            // 分数越高越靠前还是越低越靠前
+	tieBreak          TieBreakOrder    // 同分时更新时间越早还是越晚越靠前
+	withMetadata      bool             // Redis排行榜查询名次列表时一并读取玩家元数据
+	now               func() time.Time // 取当前时间，默认time.Now
+	secondaryTieBreak bool             // Redis复合分数的低位存放次要排序值
+	retries           int              // Redis暂时性错误的重试次数，0表示不重试
+	retryBackoff      time.Duration    // 首次重试前的等待时长，之后每次翻倍
+	rankMode          RankMode         // 同分之后的名次是否跳过并列人数
+	zeroBasedRanks    bool             // 返回的名次从0开始
+	metrics           MetricsObserver  // 监控回调，默认不做任何事
+}
+
+// newOptions 应用配置选项
+func newOptions(opts []Option) options {
+	o := options{
+		defaultWindow: DefaultRankRangeSize,
+		trimEvery:     1,
+		scoreBits:     defaultScoreBits,
+		now:           time.Now,
+		metrics:       noopObserver{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// ScoreQuantizer 分数量化函数，UpdateScore写入前对原始分数分档
+type ScoreQuantizer func(score int64) int64
+
+// QuantizeFloor 向下取整到k的倍数，如k=10时103和107都存为100
+func QuantizeFloor(k int64) ScoreQuantizer {
+	return func(score int64) int64 {
+		q := score / k * k
+		if score < 0 && q != score {
+			q -= k // 负数也向下取整
+		}
+		return q
+	}
+}
+
+// WithScoreQuantizer UpdateScore写入前先量化分数，存储和排名都使用量化后的分数
+// 落在同一档的分数会变成同分，按更新时间决定先后；调用方传入的原始分数不受影响，可自行记录
+func WithScoreQuantizer(q ScoreQuantizer) Option {
+	return func(o *options) {
+		o.quantizer = q
+	}
+}
+
+// quantize 按配置量化分数
+func (o *options) quantize(score int64) int64 {
+	if o.quantizer == nil {
+		return score
+	}
+	return o.quantizer(score)
+}
+
+// TiePolicy 分数不变的重复提交如何处理更新时间
+type TiePolicy int
+
+const (
+	// KeepFirst 分数不变时保留原更新时间，先达到该分数的玩家排在前面（默认）
+	KeepFirst TiePolicy = iota
+	// RefreshOnResubmit 每次提交都刷新更新时间，同分玩家中最近提交的排在最后
+	RefreshOnResubmit
+)
+
+// WithTiePolicy 设置内存排行榜同分重复提交的处理策略
+// Redis排行榜不受该选项影响，每次写入都刷新同分排序时间，相当于RefreshOnResubmit；需要两种排行榜结果一致时内存排行榜应配置RefreshOnResubmit
+func WithTiePolicy(p TiePolicy) Option {
+	return func(o *options) {
+		o.tiePolicy = p
+	}
+}
+
+// WithDefaultWindow 设置GetPlayerRankRange的n<=0时使用的窗口大小
+func WithDefaultWindow(n int) Option {
+	return func(o *options) {
+		o.defaultWindow = n
+	}
+}
+
+// WithCollisionAvoidance Redis写入前检查复合分数是否与其他玩家完全相同
+// 相同时（同分且同一秒）把本次写入的更新时间逐次后移1秒直到不再冲突，由同分排序方向决定先后，而不是由Redis按成员字典序决定：
+// 默认EarliestFirst时已占用该复合分数的玩家排在前面，WithTieBreak(LatestFirst)时本次写入的玩家排在前面；
+// 同一真实分数内已没有更晚的同分排序值时不写入，返回ErrTieBreakExhausted，真实分数不会被改变
+func WithCollisionAvoidance() Option {
+	return func(o *options) {
+		o.avoidCollision = true
+	}
+}
+
+// WithMaxSize 排行榜只保留前n名，写入后裁剪掉排名最靠后的多余玩家
+// 被裁掉的玩家之后再提交足够高的分数会正常重新上榜；内存排行榜每次新增玩家都立即裁剪，不受WithTrimEvery影响
+func WithMaxSize(n int) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithTrimEvery Redis排行榜每k次写入才裁剪一次，分摊ZREMRANGEBYRANK的开销
+// 两次裁剪之间人数最多超出上限k-1人，默认每次写入都裁剪
+func WithTrimEvery(k int) Option {
+	return func(o *options) {
+		if k > 0 {
+			o.trimEvery = k
+		}
+	}
+}
+
+// WithTombstone Redis排行榜的RemovePlayer改为软删除，玩家连同复合分数移入墓碑集合保留grace时长
+// 期间可用RestorePlayer恢复原有名次，过期的墓碑由SweepTombstones清理
+func WithTombstone(grace time.Duration) Option {
+	return func(o *options) {
+		o.tombstoneGrace = grace
+	}
+}
+
+// WithScoreBits 设置Redis复合分数中真实分数占的位数（含符号位），其余位用于按秒记录的同分排序时间
+// 两者共用float64可精确表示的53位：位数越多可写入的分数越大，同分排序时间可表示的跨度越短
+// 同分排序时间从2024-01-01 UTC起可表示2^(53-n)秒，默认25位时分数范围为[MinScore, MaxScore]，时间到2032-07-03为止；
+// 超出后所有同分玩家的排序时间相同，只按成员ID排序，与内存排行榜的结果不再一致
+// n需在[2, 52]内，否则构造函数返回错误
+// 同一个键的所有读写必须使用相同的位数
+func WithScoreBits(n int) Option {
+	return func(o *options) {
+		o.scoreBits = n
+	}
+}
+
+// Order 排名方向
+type Order int
+
+const (
+	// Descending 分数越高排名越靠前，默认
+	Descending Order = iota
+	// Ascending 分数越低排名越靠前，用于竞速、高尔夫等按用时或杆数排名的玩法
+	Ascending
+)
+
+// WithOrder 设置排名方向，同分时仍是先达到该分数的玩家排前面
+// 升序时排行榜内部保存分数的相反数，所有查询的名次、区间和边界都按该方向计算，返回的分数仍是原始分数；
+// UpdateScoreIfHigher表示“成绩更好才写入”，升序时即分数更低才写入
+// Redis排行榜中同一个键的所有读写必须使用相同的方向
+func WithOrder(order Order) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// orient 在原始分数和内部分数之间转换，内部分数总是越高越靠前；转换两次得到原值
+// 升序时取相反数，math.MinInt64按math.MaxInt64处理避免溢出
+func (o *options) orient(score int64) int64 {
+	if o.order != Ascending {
+		return score
+	}
+	if score == math.MinInt64 {
+		return math.MaxInt64
+	}
+	return -score
+}
+
+// keyRange 把原始分数闭区间换算为内部分数闭区间
+func (o *options) keyRange(minScore, maxScore int64) (lo, hi int64) {
+	if o.order != Ascending {
+		return minScore, maxScore
+	}
+	return o.orient(maxScore), o.orient(minScore)
+}
+
+// playerRank 由玩家生成排名信息，分数换算回原始分数
+func (o *options) playerRank(p *Player, rank int) PlayerRank {
+	pr := playerRank(p, o.outRank(rank))
+	pr.Score = o.orient(pr.Score)
+	return pr
+}
+
+// TieBreakOrder 同分时按更新时间排序的方向
+type TieBreakOrder int
+
+const (
+	// EarliestFirst 先达到该分数的玩家排前面，默认
+	EarliestFirst TieBreakOrder = iota
+	// LatestFirst 最近达到该分数的玩家排前面
+	LatestFirst
+)
+
+// WithTieBreak 设置同分玩家按更新时间排序的方向，两种排行榜一致生效，时间精确到秒
+// Redis排行榜中同一个键的所有读写必须使用相同的方向
+func WithTieBreak(t TieBreakOrder) Option {
+	return func(o *options) {
+		o.tieBreak = t
+	}
+}
+
+// rankBefore 按配置返回排名比较规则
+func (o *options) rankBefore() func(a, b *Player) bool {
+	if o.tieBreak == LatestFirst {
+		return rankBeforeLatest
+	}
+	return rankBefore
+}
+
+// WithMetadata Redis排行榜的GetTopN、GetRankPage、GetPlayerRankRange、GetRankAmong一并读取玩家元数据填入PlayerRank.Meta
+// 每次查询多一条HMGET；内存排行榜总是返回元数据，不需要该选项
+func WithMetadata() Option {
+	return func(o *options) {
+		o.withMetadata = true
+	}
+}
+
+// WithClock 替换排行榜取当前时间的函数，用于测试中精确控制同分先后，以及PruneInactive、墓碑过期的时间判断
+// 两种排行榜所有记录更新时间和判断过期的地方都使用该函数；now为nil时忽略
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		if now != nil {
+			o.now = now
+		}
+	}
+}
+
+// WithSecondaryTieBreak Redis排行榜的复合分数低位改为存放UpdateScoreWithTieBreak写入的次要排序值，同分时次要排序值越大越靠前
+// 低位不再记录更新时间，次要排序值也相同时按玩家ID字典序降序；默认28位时次要排序值范围约为±1.3亿
+// 按时间工作的PruneInactive、GetTopNInTimeWindow和会改写低位的Normalize返回错误，WithCollisionAvoidance不生效，
+// GetTopNTieBreak返回次要排序值而不是时间；同一个键的所有读写必须一致使用该选项
+// 内存排行榜总是支持次要排序值（优先于更新时间比较），不需要该选项
+func WithSecondaryTieBreak() Option {
+	return func(o *options) {
+		o.secondaryTieBreak = true
+	}
+}
+
+// RankMode 同分并列之后的名次规则
+type RankMode int
+
+const (
+	// StandardRanking 标准竞赛排名（1224），并列之后的名次跳过并列人数，默认
+	StandardRanking RankMode = iota
+	// DenseRanking 密集排名（1223），并列之后的名次紧接并列名次，三人并列第2名之后是第3名
+	DenseRanking
+)
+
+// WithRankMode 设置名次规则，影响GetRank、GetRankDetail、GetTopN、GetRankPage、GetPlayerRankRange等返回的名次，不影响排序
+// 密集排名时内存排行榜额外维护按分数去重的跳表；Redis排行榜的GetRank由Lua脚本逐个跳过更高的不同分数，
+// 代价随该玩家之前的不同分数个数线性增加，适合分数档位不多的榜单；ShardedRankingSystem不支持密集排名
+// Redis排行榜中同一个键的所有读取应使用相同的规则
+func WithRankMode(mode RankMode) Option {
+	return func(o *options) {
+		o.rankMode = mode
+	}
+}
+
+// newRankList 按配置创建空跳表
+func (o *options) newRankList() *rankList {
+	if o.rankMode == DenseRanking {
+		return newDenseRankList(o.rankBefore())
+	}
+	return newRankList(o.rankBefore())
+}
+
+// nextRank 已排序列表中分数与前一名不同的玩家的名次，prev为前一名的名次，position为该玩家的位置（从1开始）
+func (o *options) nextRank(prev, position int) int {
+	if o.rankMode == DenseRanking {
+		return prev + 1
+	}
+	return position
+}
+
+// sortedRanks 按配置的名次规则计算已排序玩家的名次
+func (o *options) sortedRanks(players []*Player) []int {
+	if o.rankMode != DenseRanking {
+		return competitionRanks(players)
+	}
+	ranks := make([]int, len(players))
+	for i, p := range players {
+		switch {
+		case i == 0:
+			ranks[i] = 1
+		case p.Score == players[i-1].Score:
+			ranks[i] = ranks[i-1]
+		default:
+			ranks[i] = ranks[i-1] + 1
+		}
+	}
+	return ranks
+}
+
+// WithZeroBasedRanks 返回的名次从0开始，第一名为0，并列规则不变，只改变输出的数字
+// 对两种排行榜返回名次的方法一致生效，包括GetRank、GetTopN、GetRankPage、GetPlayerRankRange、GetRankRange；
+// GetScoreRankForList中不在榜的玩家Rank为-1；OnRankChange回调的名次和Position仍从1开始，名次变化量不受影响
+func WithZeroBasedRanks() Option {
+	return func(o *options) {
+		o.zeroBasedRanks = true
+	}
+}
+
+// outRank 把内部从1开始的名次换算为返回给调用方的名次
+func (o *options) outRank(rank int) int {
+	if o.zeroBasedRanks {
+		return rank - 1
+	}
+	return rank
+}
+
+// outRanks 原地换算一组内部名次，返回同一个切片
+func (o *options) outRanks(rankings []PlayerRank) []PlayerRank {
+	if o.zeroBasedRanks {
+		for i := range rankings {
+			rankings[i].Rank--
+		}
+	}
+	return rankings
+}
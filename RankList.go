@@ -0,0 +1,260 @@
+package game_rank_test
+
+import "math/rand"
+
+// rankListMaxLevel 跳表最大层数，按1/4的晋升概率足以容纳远超内存容量的玩家数
+const rankListMaxLevel = 32
+
+// rankList 按排名规则before排序的跳表，每层指针记录跨越的节点数，
+// 插入、删除、按名次取玩家、查询玩家下标都是O(log n)，结构同Redis的zskiplist
+// 节点直接引用Player，修改玩家的分数或更新时间前必须先remove，改完再insert
+type rankList struct {
+	before func(a, b *Player) bool
+	head   *rankNode
+	level  int
+	length int
+
+	distinct *rankList             // 每个不同分数一个节点，用于密集名次，nil表示不统计
+	scores   map[int64]*scoreEntry // 各分数在distinct中的节点和人数
+}
+
+// scoreEntry 某个分数在distinct中的代表节点及该分数的人数
+type scoreEntry struct {
+	player *Player
+	count  int
+}
+
+// rankNode 跳表节点
+type rankNode struct {
+	player *Player
+	levels []rankLevel
+}
+
+// rankLevel 节点某一层的后继及跨越的节点数
+type rankLevel struct {
+	next *rankNode
+	span int
+}
+
+// newRankList 创建按before排序的空跳表
+func newRankList(before func(a, b *Player) bool) *rankList {
+	return &rankList{
+		before: before,
+		head:   &rankNode{levels: make([]rankLevel, rankListMaxLevel)},
+		level:  1,
+	}
+}
+
+// newDenseRankList 创建按before排序的空跳表，同时统计不同分数，用于密集名次
+func newDenseRankList(before func(a, b *Player) bool) *rankList {
+	l := newRankList(before)
+	l.distinct = newRankList(func(a, b *Player) bool { return a.Score > b.Score })
+	l.scores = make(map[int64]*scoreEntry)
+	return l
+}
+
+// randomLevel 新节点的层数
+func randomLevel() int {
+	level := 1
+	for level < rankListMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// len 玩家数
+func (l *rankList) len() int {
+	return l.length
+}
+
+// insert 按当前分数和更新时间插入玩家，玩家不能已在表中
+func (l *rankList) insert(p *Player) {
+	var update [rankListMaxLevel]*rankNode
+	var rank [rankListMaxLevel]int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && l.before(x.levels[i].next.player, p) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+			l.head.levels[i].span = l.length
+		}
+		l.level = level
+	}
+
+	n := &rankNode{player: p, levels: make([]rankLevel, level)}
+	for i := 0; i < level; i++ {
+		n.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = n
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+	l.length++
+
+	if l.distinct != nil {
+		e, ok := l.scores[p.Score]
+		if !ok {
+			e = &scoreEntry{player: &Player{Score: p.Score}}
+			l.scores[p.Score] = e
+			l.distinct.insert(e.player)
+		}
+		e.count++
+	}
+}
+
+// remove 删除玩家，玩家的分数和更新时间需与插入时一致，返回是否找到
+func (l *rankList) remove(p *Player) bool {
+	var update [rankListMaxLevel]*rankNode
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && l.before(x.levels[i].next.player, p) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].next
+	if x == nil || x.player != p {
+		return false
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].next == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].next = x.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	for l.level > 1 && l.head.levels[l.level-1].next == nil {
+		l.level--
+	}
+	l.length--
+
+	if l.distinct != nil {
+		if e := l.scores[p.Score]; e != nil {
+			if e.count--; e.count == 0 {
+				l.distinct.remove(e.player)
+				delete(l.scores, p.Score)
+			}
+		}
+	}
+	return true
+}
+
+// indexOf 玩家的下标（从0开始），不在表中返回-1
+func (l *rankList) indexOf(p *Player) int {
+	x := l.head
+	traversed := 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && !l.before(p, x.levels[i].next.player) {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if x.player == p {
+			return traversed - 1
+		}
+	}
+	return -1
+}
+
+// nodeAt 下标i（从0开始）处的节点，越界返回nil
+func (l *rankList) nodeAt(i int) *rankNode {
+	if i < 0 || i >= l.length {
+		return nil
+	}
+
+	x := l.head
+	traversed := 0
+	for lv := l.level - 1; lv >= 0; lv-- {
+		for x.levels[lv].next != nil && traversed+x.levels[lv].span <= i+1 {
+			traversed += x.levels[lv].span
+			x = x.levels[lv].next
+		}
+		if traversed == i+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// at 下标i（从0开始）处的玩家，越界返回nil
+func (l *rankList) at(i int) *Player {
+	if n := l.nodeAt(i); n != nil {
+		return n.player
+	}
+	return nil
+}
+
+// page 从下标offset起最多limit名玩家
+func (l *rankList) page(offset, limit int) []*Player {
+	n := l.nodeAt(offset)
+	if n == nil || limit <= 0 {
+		return nil
+	}
+
+	players := make([]*Player, 0, min(limit, l.length-offset))
+	for ; n != nil && len(players) < limit; n = n.levels[0].next {
+		players = append(players, n.player)
+	}
+	return players
+}
+
+// countBefore 按排名规则排在p之前的玩家数，p不必在表中
+func (l *rankList) countBefore(p *Player) int {
+	x := l.head
+	traversed := 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && l.before(x.levels[i].next.player, p) {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+	}
+	return traversed
+}
+
+// countAbove 分数严格高于score的玩家数
+func (l *rankList) countAbove(score int64) int {
+	x := l.head
+	traversed := 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.player.Score > score {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+	}
+	return traversed
+}
+
+// countDistinctAbove 严格高于score的不同分数个数，需由newDenseRankList创建
+func (l *rankList) countDistinctAbove(score int64) int {
+	return l.distinct.countAbove(score)
+}
+
+// countAtLeast 分数不低于score的玩家数
+func (l *rankList) countAtLeast(score int64) int {
+	x := l.head
+	traversed := 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.player.Score >= score {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+	}
+	return traversed
+}
@@ -0,0 +1,46 @@
+package game_rank_test
+
+import "time"
+
+// PlayerRank 玩家排名信息
+type PlayerRank struct {
+	PlayerID   string
+	Score      int64
+	Rank       int
+	Position   int        // 在返回窗口中的位置（从1开始），仅GetPlayerRankRange填写
+	UpdateTime time.Time  // 分数最后更新时间，用于展示同分先后，仅内存排行榜填写
+	Meta       PlayerMeta // 玩家元数据，Redis排行榜需配置WithMetadata才填写；与排行榜共享，不要修改
+}
+
+// RankChangeFunc 玩家名次变化的回调，oldRank为0表示新上榜，newRank为0表示本次写入后因WithMaxSize被裁剪出榜
+// 内存排行榜只在新增玩家时裁剪，被更新的已有玩家不会出榜，因此只有Redis排行榜会报告newRank为0；新玩家写入后随即被裁剪时不回调
+type RankChangeFunc func(playerID string, oldRank, newRank int)
+
+// Ranker 排行榜的通用操作，内存排行榜和Redis排行榜都实现了该接口，
+// 便于测试时使用内存实现、线上使用Redis实现
+type Ranker interface {
+	// UpdateScore 更新玩家积分
+	UpdateScore(playerID string, score int64) error
+	// GetRank 查询玩家当前名次和分数
+	GetRank(playerID string) (int, int64, error)
+	// GetScore 查询玩家当前分数，玩家不存在时返回ErrPlayerNotFound
+	GetScore(playerID string) (int64, error)
+	// Exists 玩家是否在榜
+	Exists(playerID string) (bool, error)
+	// GetTopN 获取前N名玩家的分数和名次
+	GetTopN(n int) ([]PlayerRank, error)
+	// GetRankPage 获取从offset（从0开始）起的limit名玩家，名次按全榜并列规则计算
+	GetRankPage(offset, limit int) ([]PlayerRank, error)
+	// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
+	GetPlayerRankRange(playerID string, n int) ([]PlayerRank, error)
+	// RemovePlayer 移除玩家，wasPresent表示调用前玩家是否在榜
+	RemovePlayer(playerID string) (wasPresent bool, err error)
+	// GetTotalPlayers 获取总玩家数
+	GetTotalPlayers() (int64, error)
+}
+
+var (
+	_ Ranker = (*RankingSystem)(nil)
+	_ Ranker = (*RedisRankingList)(nil)
+	_ Ranker = (*ShardedRankingSystem)(nil)
+)
@@ -0,0 +1,47 @@
+package game_rank_test
+
+import "context"
+
+// WithContext 返回使用ctx执行所有Redis命令的排行榜副本，用于设置单次请求的超时或随HTTP请求取消
+// 副本与原排行榜共用连接、配置和写入计数，原排行榜构造时的上下文继续作为默认值
+// ctx取消后命令立即返回，错误中包装了ctx.Err()，可用errors.Is判断
+func (r *RedisRankingList) WithContext(ctx context.Context) *RedisRankingList {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+// UpdateScoreCtx 同UpdateScore，使用调用方的上下文
+func (r *RedisRankingList) UpdateScoreCtx(ctx context.Context, playerID string, score int64) error {
+	return r.WithContext(ctx).UpdateScore(playerID, score)
+}
+
+// GetRankCtx 同GetRank，使用调用方的上下文
+func (r *RedisRankingList) GetRankCtx(ctx context.Context, playerID string) (int, int64, error) {
+	return r.WithContext(ctx).GetRank(playerID)
+}
+
+// GetTopNCtx 同GetTopN，使用调用方的上下文
+func (r *RedisRankingList) GetTopNCtx(ctx context.Context, n int) ([]PlayerRank, error) {
+	return r.WithContext(ctx).GetTopN(n)
+}
+
+// GetRankPageCtx 同GetRankPage，使用调用方的上下文
+func (r *RedisRankingList) GetRankPageCtx(ctx context.Context, offset, limit int) ([]PlayerRank, error) {
+	return r.WithContext(ctx).GetRankPage(offset, limit)
+}
+
+// GetPlayerRankRangeCtx 同GetPlayerRankRange，使用调用方的上下文
+func (r *RedisRankingList) GetPlayerRankRangeCtx(ctx context.Context, playerID string, n int) ([]PlayerRank, error) {
+	return r.WithContext(ctx).GetPlayerRankRange(playerID, n)
+}
+
+// RemovePlayerCtx 同RemovePlayer，使用调用方的上下文
+func (r *RedisRankingList) RemovePlayerCtx(ctx context.Context, playerID string) (bool, error) {
+	return r.WithContext(ctx).RemovePlayer(playerID)
+}
+
+// GetTotalPlayersCtx 同GetTotalPlayers，使用调用方的上下文
+func (r *RedisRankingList) GetTotalPlayersCtx(ctx context.Context) (int64, error) {
+	return r.WithContext(ctx).GetTotalPlayers()
+}
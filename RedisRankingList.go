@@ -1,9 +1,15 @@
 package game_rank_test
 
 import (
+	"container/heap"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"sort"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,19 +18,26 @@ import (
 // RedisRankingList 基于Redis ZSet的排行榜系统
 type RedisRankingList struct {
 	client *redis.Client
+	owned  bool            // 客户端由构造函数创建，Close时需要关闭
 	key    string          // Redis中存储排行榜的键名
 	ctx    context.Context // 上下文
+	opts   options
+	codec  compositeCodec // 复合分数编码，由WithScoreBits决定位数
+	writes *uint64        // 写入次数，用于按频率裁剪，WithContext派生的实例共用
+
+	onRankChange *atomic.Value // 保存RankChangeFunc，写入改变玩家名次时调用，WithContext派生的实例共用
 }
 
-// PlayerRank 玩家排名信息
-type PlayerRank struct {
-	PlayerID string
-	Score    int64
-	Rank     int
+// TieBreakEntry 排名及同分时的排序依据，用于解释同分玩家的先后
+// 同分玩家依次比较Tiebreak（越大越靠前）、TieBreak（精确到秒，默认越早越靠前，见WithTieBreak），都相同时按PlayerID字典序降序
+type TieBreakEntry struct {
+	PlayerRank
+	Tiebreak int64     // 次要排序值，见UpdateScoreWithTieBreak；Redis排行榜未配置WithSecondaryTieBreak时为0
+	TieBreak time.Time // 同分时比较的更新时间，截断到秒；Redis排行榜配置WithSecondaryTieBreak时为零值
 }
 
-// NewRedisRankingSystem 创建一个新的Redis排行榜系统
-func NewRedisRankingSystem(addr string, password string, db int, key string) *RedisRankingList {
+// NewRedisRankingSystem 连接Redis并创建排行榜，连接失败时返回错误
+func NewRedisRankingSystem(addr string, password string, db int, key string, opts ...Option) (*RedisRankingList, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
 		Password: password,
@@ -32,166 +45,2252 @@ func NewRedisRankingSystem(addr string, password string, db int, key string) *Re
 	})
 
 	// 测试连接
-	ctx := context.Background()
-	if err := client.Ping(ctx).Err(); err != nil {
-		panic(fmt.Sprintf("无法连接到Redis: %v", err))
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("无法连接到Redis: %w", err)
+	}
+
+	r, err := NewRedisRankingWithClient(client, key, opts...)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	r.owned = true
+	return r, nil
+}
+
+// NewRedisRankingWithClient 使用调用方已有的客户端创建排行榜，不检查连接
+// 客户端的连接池和生命周期仍由调用方管理；选项无效时返回错误
+func NewRedisRankingWithClient(client *redis.Client, key string, opts ...Option) (*RedisRankingList, error) {
+	o := newOptions(opts)
+	codec, err := newCompositeCodec(o.scoreBits, o.tieBreak, o.secondaryTieBreak)
+	if err != nil {
+		return nil, err
 	}
 
 	return &RedisRankingList{
 		client: client,
 		key:    key,
-		ctx:    ctx,
+		ctx:    context.Background(),
+		opts:   o,
+		codec:  codec,
+		writes: new(uint64),
+
+		onRankChange: new(atomic.Value),
+	}, nil
+}
+
+// Close 关闭由NewRedisRankingSystem创建的客户端，通过NewRedisRankingWithClient传入的客户端不会被关闭
+func (r *RedisRankingList) Close() error {
+	if !r.owned {
+		return nil
+	}
+	return r.client.Close()
+}
+
+// errSecondaryTieBreak 按更新时间工作的方法在配置了WithSecondaryTieBreak时返回的错误
+var errSecondaryTieBreak = errors.New("配置了WithSecondaryTieBreak时复合分数不含更新时间")
+
+// writeTieBreak 普通写入使用的同分排序值：默认为当前时间，配置了WithSecondaryTieBreak时为次要排序值0
+func (r *RedisRankingList) writeTieBreak() int64 {
+	if r.codec.secondary {
+		tieBreak, _ := r.codec.secondaryOf(0)
+		return tieBreak
 	}
+	return r.codec.tieBreakOf(r.opts.now())
 }
 
 // UpdateScore 更新玩家积分
+// 分数需在WithScoreBits决定的范围内（默认[MinScore, MaxScore]），否则返回ErrScoreOutOfRange
+func (r *RedisRankingList) UpdateScore(playerID string, score int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScore", time.Now(), &err)
+	tieBreak := r.writeTieBreak()
+	return r.retry(func() error {
+		return r.updateScore(playerID, score, tieBreak)
+	})
+}
+
+// UpdateScoreWithTieBreak 同UpdateScore，同时写入次要排序值tiebreak，同分时tiebreak越大越靠前
+// 只决定同分玩家的先后，名次仍按分数并列；需配置WithSecondaryTieBreak，tiebreak超出低位可表示的范围时返回ErrScoreOutOfRange；
+// 其他写入方法的次要排序值按0处理
+func (r *RedisRankingList) UpdateScoreWithTieBreak(playerID string, score, tiebreak int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScoreWithTieBreak", time.Now(), &err)
+	if !r.codec.secondary {
+		return fmt.Errorf("写入次要排序值需配置WithSecondaryTieBreak")
+	}
+	tieBreak, err := r.codec.secondaryOf(tiebreak)
+	if err != nil {
+		return err
+	}
+	return r.retry(func() error {
+		return r.updateScore(playerID, score, tieBreak)
+	})
+}
 
-func (r *RedisRankingList) UpdateScore(playerID string, score int64) error {
-	// 生成复合分数：主分数左移40位，减去当前时间戳（毫秒）方便时间戳存储
-	timestamp := time.Now().UnixMilli()
-	compositeScore := float64(score<<40 - timestamp)
+// updateScore 以同分排序值tieBreak写入原始分数
+func (r *RedisRankingList) updateScore(playerID string, score, tieBreak int64) error {
+	score = r.opts.orient(r.opts.quantize(score))
+	if err := r.codec.check(score); err != nil {
+		return err
+	}
+
+	composite := r.codec.encode(score, tieBreak)
+	if notify := r.rankChangeFunc(); notify != nil {
+		return r.updateScoreNotify(playerID, score, composite, notify)
+	}
+	if r.shouldTrim() {
+		// 写入和裁剪在同一个管道中发出
+		_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			r.queueComposite(pipe, playerID, composite)
+			r.queueTrim(pipe)
+			return nil
+		})
+		return tieBreakErr(err)
+	}
+	if r.opts.avoidCollision && !r.codec.secondary {
+		return tieBreakErr(zaddNoCollision.Run(r.ctx, r.client, []string{r.key}, r.noCollisionArgs(playerID, composite)...).Err())
+	}
 
 	return r.client.ZAdd(r.ctx, r.key, &redis.Z{
-		Score:  compositeScore,
+		Score:  composite,
 		Member: playerID,
 	}).Err()
 }
 
+// OnRankChange 注册名次变化回调，UpdateScore、UpdateScoreWithTieBreak、UpdateScores、IncrementScore和UpdateScoreIfHigher
+// 使被更新玩家的名次变化时同步调用；EnsurePlayer、BulkLoad、Merge等其他写入不回调；可与写入并发调用，传nil取消注册
+// 只报告被更新的玩家；注册后UpdateScore多一次往返，其余写入前后各多两次往返
+func (r *RedisRankingList) OnRankChange(fn RankChangeFunc) {
+	r.onRankChange.Store(fn)
+}
+
+// rankChangeFunc 当前注册的名次变化回调，未注册时返回nil
+func (r *RedisRankingList) rankChangeFunc() RankChangeFunc {
+	fn, _ := r.onRankChange.Load().(RankChangeFunc)
+	return fn
+}
+
+// notifyWrite 注册了名次变化回调时在write前后分别读取playerIDs的名次，对名次变化的玩家回调
+// 读取与写入不在同一个事务中，并发写入时报告的名次可能包含其他写入的影响；写入后读取名次失败时不回调
+func (r *RedisRankingList) notifyWrite(playerIDs []string, write func() error) error {
+	notify := r.rankChangeFunc()
+	if notify == nil {
+		return write()
+	}
+
+	oldRanks, err := r.notifiedRanks(playerIDs)
+	if err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	newRanks, err := r.notifiedRanks(playerIDs)
+	if err != nil {
+		return nil
+	}
+	for playerID, oldRank := range oldRanks {
+		if newRank := newRanks[playerID]; newRank != oldRank {
+			notify(playerID, oldRank, newRank)
+		}
+	}
+	return nil
+}
+
+// notifiedRanks 名次变化回调使用的名次，不在榜的玩家为0，与WithZeroBasedRanks无关总是从1开始
+func (r *RedisRankingList) notifiedRanks(playerIDs []string) (map[string]int, error) {
+	current, err := r.ranks(playerIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(playerIDs))
+	for _, playerID := range playerIDs {
+		if pr, ok := current[playerID]; ok {
+			result[playerID] = pr.Rank - r.opts.outRank(1) + 1
+		} else {
+			result[playerID] = 0
+		}
+	}
+	return result, nil
+}
+
+// updateScoreNotify 写入分数并比较写入前后的名次，score为内部分数
+// 先读旧分数，再在MULTI事务中统计旧名次、写入、裁剪并统计新名次，前后名次基于同一时刻的榜单
+func (r *RedisRankingList) updateScoreNotify(playerID string, score int64, composite float64, notify RankChangeFunc) error {
+	oldComposite, err := r.client.ZScore(r.ctx, r.key, playerID).Result()
+	exists := err == nil
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("获取分数失败: %w", err)
+	}
+
+	var oldAbove, newAbove func() int64
+	var kept *redis.FloatCmd
+	_, err = r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		if exists {
+			oldAbove = r.queueRankAbove(pipe, r.keyScore(oldComposite))
+		}
+		r.queueComposite(pipe, playerID, composite)
+		if r.shouldTrim() {
+			r.queueTrim(pipe)
+			kept = pipe.ZScore(r.ctx, r.key, playerID)
+		}
+		newAbove = r.queueRankAbove(pipe, score)
+		return nil
+	})
+	// 只有裁剪后的ZSCORE会返回redis.Nil，表示被更新的玩家已被裁剪出榜
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("更新分数失败: %w", tieBreakErr(err))
+	}
+
+	oldRank := 0
+	if exists {
+		oldRank = int(oldAbove()) + 1
+	}
+	newRank := int(newAbove()) + 1
+	if kept != nil && kept.Err() == redis.Nil {
+		newRank = 0
+	}
+	if newRank != oldRank {
+		notify(playerID, oldRank, newRank)
+	}
+	return nil
+}
+
+// queueRankAbove 在管道中加入一条rankAbove统计，score为内部分数，返回在管道执行后读取结果的函数
+func (r *RedisRankingList) queueRankAbove(pipe redis.Pipeliner, score int64) func() int64 {
+	if r.opts.rankMode == DenseRanking {
+		cmd := distinctAbove.Eval(r.ctx, pipe, []string{r.key}, score, r.codec.unit())
+		return func() int64 {
+			n, _ := cmd.Int64()
+			return n
+		}
+	}
+	return pipe.ZCount(r.ctx, r.key, r.codec.aboveBound(score), "+inf").Val
+}
+
+// queueComposite 在管道中加入一条复合分数写入，按配置决定是否避开冲突
+// 管道中EVALSHA遇到NOSCRIPT无法回退，因此直接使用EVAL；与updateScore相同，低位存放次要排序值时不避让
+func (r *RedisRankingList) queueComposite(pipe redis.Pipeliner, playerID string, composite float64) {
+	if r.opts.avoidCollision && !r.codec.secondary {
+		zaddNoCollision.Eval(r.ctx, pipe, []string{r.key}, r.noCollisionArgs(playerID, composite)...)
+		return
+	}
+	pipe.ZAdd(r.ctx, r.key, &redis.Z{
+		Score:  composite,
+		Member: playerID,
+	})
+}
+
+// shouldTrim 本次写入后是否需要裁剪
+func (r *RedisRankingList) shouldTrim() bool {
+	if r.opts.maxSize <= 0 {
+		return false
+	}
+	return atomic.AddUint64(r.writes, 1)%uint64(r.opts.trimEvery) == 0
+}
+
+// queueTrim 裁剪排行榜，删除前maxSize名以外的玩家，c可以是管道
+func (r *RedisRankingList) queueTrim(c redis.Cmdable) *redis.IntCmd {
+	return c.ZRemRangeByRank(r.ctx, r.key, 0, -int64(r.opts.maxSize)-1)
+}
+
+// noCollisionArgs zaddNoCollision的参数，按同分排序方向决定步进和边界
+// EarliestFirst时低位越小表示越晚，逐次减1直到同一真实分数的最小复合分数；
+// LatestFirst时低位越大表示越晚，逐次加1直到同一真实分数的最大复合分数
+func (r *RedisRankingList) noCollisionArgs(playerID string, composite float64) []interface{} {
+	score, _ := r.codec.split(composite)
+	if r.codec.latestFirst {
+		return []interface{}{playerID, composite, r.codec.floor(score) + float64(r.codec.unit()-1), 1}
+	}
+	return []interface{}{playerID, composite, r.codec.floor(score), -1}
+}
+
+// zaddNoCollision 写入复合分数，已被其他玩家占用时按步进逐次移动1（更新时间后移1秒）直到空闲
+// 低位已到边界仍冲突时不写入并返回错误，不会落到相邻一分的复合分数范围里改变真实分数
+// KEYS[1] 排行榜键  ARGV[1] 玩家ID  ARGV[2] 复合分数  ARGV[3] 同一真实分数内可移动到的边界  ARGV[4] 步进（1或-1）
+var zaddNoCollision = redis.NewScript(`
+local score = tonumber(ARGV[2])
+local bound = tonumber(ARGV[3])
+local step = tonumber(ARGV[4])
+for i = 1, 64 do
+	local taken = false
+	for _, m in ipairs(redis.call('ZRANGEBYSCORE', KEYS[1], score, score, 'LIMIT', 0, 2)) do
+		if m ~= ARGV[1] then
+			taken = true
+		end
+	end
+	if not taken then
+		break
+	end
+	if score == bound then
+		return redis.error_reply('` + tieBreakExhaustedPrefix + ` no free tie-break slot at this score')
+	end
+	score = score + step
+end
+return redis.call('ZADD', KEYS[1], score, ARGV[1])
+`)
+
+// UpdateScores 批量更新玩家积分，所有写入通过一个管道一次往返发出
+// 每个玩家的复合分数编码与UpdateScore相同
+func (r *RedisRankingList) UpdateScores(updates map[string]int64) (err error) {
+	defer r.opts.observeUpdate("UpdateScores", time.Now(), &err)
+	if len(updates) == 0 {
+		return nil
+	}
+
+	tieBreak := r.writeTieBreak()
+	for playerID, score := range updates {
+		if err := r.codec.check(r.opts.orient(r.opts.quantize(score))); err != nil {
+			return fmt.Errorf("玩家%s: %w", playerID, err)
+		}
+	}
+
+	playerIDs := make([]string, 0, len(updates))
+	for playerID := range updates {
+		playerIDs = append(playerIDs, playerID)
+	}
+	err = r.notifyWrite(playerIDs, func() error {
+		return r.retry(func() error {
+			_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+				for playerID, score := range updates {
+					r.queueComposite(pipe, playerID, r.codec.encode(r.opts.orient(r.opts.quantize(score)), tieBreak))
+				}
+				if r.opts.maxSize > 0 {
+					r.queueTrim(pipe)
+				}
+				return nil
+			})
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("批量更新分数失败: %w", tieBreakErr(err))
+	}
+	return nil
+}
+
+// IncrementScore 在玩家当前分数上增加delta（可为负），玩家不存在时从0开始，返回新分数
+// 复合分数不能直接ZINCRBY，由Lua脚本在服务端解码、相加并用新时间戳重新编码，保证原子性
+// 增量不经过ScoreQuantizer，结果超出可表示范围时不写入并返回ErrScoreOutOfRange
+func (r *RedisRankingList) IncrementScore(playerID string, delta int64) (newScore int64, err error) {
+	defer r.opts.observeUpdate("IncrementScore", time.Now(), &err)
+	err = r.notifyWrite([]string{playerID}, func() error {
+		res, err := incrementComposite.Run(r.ctx, r.client, []string{r.key},
+			playerID, r.opts.orient(delta), r.codec.encode(0, r.writeTieBreak()), r.codec.unit(), r.codec.minScore, r.codec.maxScore).Int64Slice()
+		if err != nil {
+			return fmt.Errorf("增加分数失败: %w", err)
+		}
+		if res[1] == 0 {
+			return fmt.Errorf("增加分数失败: %w", r.codec.check(res[0]))
+		}
+		newScore = r.opts.orient(res[0])
+
+		if r.shouldTrim() {
+			if err := r.queueTrim(r.client).Err(); err != nil {
+				return fmt.Errorf("裁剪排行榜失败: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newScore, nil
+}
+
+// incrementComposite 解码复合分数、加上增量后重新编码，与compositeScore/splitComposite保持一致
+// 返回{新分数, 是否写入}
+// KEYS[1] 排行榜键  ARGV[1] 玩家ID  ARGV[2] 增量  ARGV[3] 复合分数低位  ARGV[4] 真实分数的单位  ARGV[5..6] 分数范围
+var incrementComposite = redis.NewScript(`
+local unit = tonumber(ARGV[4])
+local score = 0
+local composite = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if composite then
+	score = math.floor(tonumber(composite) / unit)
+end
+score = score + tonumber(ARGV[2])
+if score < tonumber(ARGV[5]) or score > tonumber(ARGV[6]) then
+	return {score, 0}
+end
+redis.call('ZADD', KEYS[1], score * unit + tonumber(ARGV[3]), ARGV[1])
+return {score, 1}
+`)
+
+// UpdateScoreIfHigher 只有新分数高于当前分数（或玩家不在榜）时才写入，用于只记录个人最佳的排行榜
+// 比较和写入由Lua脚本在服务端完成，并发提交不会互相覆盖；updated表示本次是否写入
+func (r *RedisRankingList) UpdateScoreIfHigher(playerID string, score int64) (updated bool, err error) {
+	defer r.opts.observeUpdate("UpdateScoreIfHigher", time.Now(), &err)
+	score = r.opts.orient(r.opts.quantize(score))
+	if err := r.codec.check(score); err != nil {
+		return false, err
+	}
+
+	composite := r.codec.encode(score, r.writeTieBreak())
+	err = r.notifyWrite([]string{playerID}, func() error {
+		n, err := zaddIfHigher.Run(r.ctx, r.client, []string{r.key},
+			playerID, composite, score, r.codec.unit()).Int()
+		if err != nil {
+			return fmt.Errorf("更新分数失败: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		updated = true
+		if r.shouldTrim() {
+			if err := r.queueTrim(r.client).Err(); err != nil {
+				return fmt.Errorf("裁剪排行榜失败: %w", err)
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// zaddIfHigher 解码当前复合分数中的真实分数，新分数更高时才写入，返回是否写入
+// KEYS[1] 排行榜键  ARGV[1] 玩家ID  ARGV[2] 新复合分数  ARGV[3] 新真实分数  ARGV[4] 真实分数的单位
+var zaddIfHigher = redis.NewScript(`
+local composite = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if composite and math.floor(tonumber(composite) / tonumber(ARGV[4])) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+
+// EnsurePlayer 玩家不在榜时以0分加入，已在榜时不覆盖原分数
+// created表示本次是否新加入了玩家；配置了WithMaxSize时与UpdateScore一样在同一个管道中裁剪，新加入的玩家可能随即被裁掉
+func (r *RedisRankingList) EnsurePlayer(playerID string) (created bool, err error) {
+	defer r.opts.observeUpdate("EnsurePlayer", time.Now(), &err)
+	var addedCmd *redis.IntCmd
+	_, err = r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		addedCmd = pipe.ZAddNX(r.ctx, r.key, &redis.Z{
+			Score:  r.codec.encode(0, r.writeTieBreak()),
+			Member: playerID,
+		})
+		if r.shouldTrim() {
+			r.queueTrim(pipe)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("添加玩家失败: %w", err)
+	}
+	return addedCmd.Val() > 0, nil
+}
+
 // GetRealScore 从复合分数中提取真实分数
 func (r *RedisRankingList) GetRealScore(compositeScore float64) int64 {
-	return int64(compositeScore) >> 40
+	return r.opts.orient(r.keyScore(compositeScore))
+}
+
+// keyScore 从复合分数中提取内部分数，内部分数总是越高越靠前
+func (r *RedisRankingList) keyScore(composite float64) int64 {
+	score, _ := r.codec.split(composite)
+	return score
+}
+
+// decodePlayer 由复合分数还原玩家，Score为内部分数，用于按rankBefore在本地排序
+// 配置了WithSecondaryTieBreak时还原Tiebreak，否则还原精确到秒的UpdateTime
+func (r *RedisRankingList) decodePlayer(playerID string, composite float64) *Player {
+	score, tieBreak := r.codec.split(composite)
+	if r.codec.secondary {
+		return &Player{ID: playerID, Score: score, Tiebreak: r.codec.secondaryValue(tieBreak)}
+	}
+	return &Player{ID: playerID, Score: score, UpdateTime: r.codec.tieBreakTime(tieBreak)}
 }
 
 // GetRank 查询玩家当前排名
-func (r *RedisRankingList) GetRank(playerID string) (int, int64, error) {
-	// ZRank返回的是升序排名，我们需要转换为降序排名
-	rank, err := r.client.ZRank(r.ctx, r.key, playerID).Result()
+// 同分并列取并列中的第一名，即更高分人数+1，与GetTopN等批量查询的名次一致
+// 分数和名次由Lua脚本在服务端一次读出，其他客户端的写入不会插在两者之间
+func (r *RedisRankingList) GetRank(playerID string) (rank int, score int64, err error) {
+	defer r.opts.observeQuery("GetRank", time.Now(), &err)
+	err = r.retry(func() (err error) {
+		rank, score, _, err = r.rankOfMember(playerID)
+		return err
+	})
 	if err != nil {
-		return 0, 0, fmt.Errorf("获取排名失败: %v", err)
+		return 0, 0, err
 	}
+	return r.opts.outRank(rank), score, nil
+}
 
-	// 获取玩家分数
-	score, err := r.client.ZScore(r.ctx, r.key, playerID).Result()
+// GetRankDetail 同GetRank，同时返回总人数，用于展示“第42名/共9000人”
+// 三者由同一个Lua脚本读取，一次往返且彼此一致，不需要再单独调用GetTotalPlayers
+func (r *RedisRankingList) GetRankDetail(playerID string) (rank int, score int64, total int64, err error) {
+	defer r.opts.observeQuery("GetRankDetail", time.Now(), &err)
+	err = r.retry(func() (err error) {
+		rank, score, total, err = r.rankOfMember(playerID)
+		return err
+	})
 	if err != nil {
-		return 0, 0, fmt.Errorf("获取分数失败: %v", err)
+		return 0, 0, 0, err
 	}
+	return r.opts.outRank(rank), score, total, nil
+}
 
-	// 转换为降序排名（+1是因为排名从1开始）
-	total, err := r.client.ZCard(r.ctx, r.key).Result()
+// rankOfMember 原子地读取玩家的名次、真实分数和总人数
+func (r *RedisRankingList) rankOfMember(playerID string) (rank int, score int64, total int64, err error) {
+	vals, err := rankOfMember.Run(r.ctx, r.client, []string{r.key}, playerID, r.codec.unit(), r.denseFlag()).Int64Slice()
+	if err == redis.Nil {
+		return 0, 0, 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("获取玩家排名失败: %w", err)
+	}
+	return int(vals[1]), r.opts.orient(vals[0]), vals[2], nil
+}
+
+// luaDistinctAbove 统计复合分数对应的真实分数严格高于score的不同分数个数，每次跳过一个分数档，供密集名次使用
+// 边界拼成字符串时用%d格式化，避免Lua默认的14位有效数字丢失精度
+const luaDistinctAbove = `
+local function distinctAbove(key, score, unit)
+	local count = 0
+	local max = '+inf'
+	local min = (score + 1) * unit
+	while true do
+		local top = redis.call('ZREVRANGEBYSCORE', key, max, min, 'WITHSCORES', 'LIMIT', 0, 1)
+		if #top == 0 then
+			return count
+		end
+		count = count + 1
+		max = string.format('(%d', math.floor(tonumber(top[2]) / unit) * unit)
+	end
+end
+`
+
+// rankOfMember 读取玩家的内部分数、名次（更高分人数+1，密集排名时为更高的不同分数个数+1）和总人数，玩家不存在时返回nil
+// KEYS[1] 排行榜  ARGV[1] 玩家ID  ARGV[2] 真实分数的单位  ARGV[3] 为1时按密集排名计算
+var rankOfMember = redis.NewScript(luaDistinctAbove + `
+local composite = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not composite then
+	return false
+end
+local unit = tonumber(ARGV[2])
+local score = math.floor(tonumber(composite) / unit)
+local above
+if ARGV[3] == '1' then
+	above = distinctAbove(KEYS[1], score, unit)
+else
+	above = redis.call('ZCOUNT', KEYS[1], (score + 1) * unit, '+inf')
+end
+return {score, above + 1, redis.call('ZCARD', KEYS[1])}
+`)
+
+// distinctAbove 只统计更高的不同分数个数
+// KEYS[1] 排行榜  ARGV[1] 内部分数  ARGV[2] 真实分数的单位
+var distinctAbove = redis.NewScript(luaDistinctAbove + `
+return distinctAbove(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]))
+`)
+
+// denseFlag 传给Lua脚本的密集排名标志
+func (r *RedisRankingList) denseFlag() int {
+	if r.opts.rankMode == DenseRanking {
+		return 1
+	}
+	return 0
+}
+
+// GetRankForScore 预估以score上榜时的名次，不写入任何数据，只需一次ZCOUNT
+// 分数先按ScoreQuantizer量化，同分视为刚达到该分数，与已有同分玩家并列
+func (r *RedisRankingList) GetRankForScore(score int64) (_ int, err error) {
+	defer r.opts.observeQuery("GetRankForScore", time.Now(), &err)
+	above, err := r.rankAbove(r.opts.quantize(score))
+	if err != nil {
+		return 0, err
+	}
+	return r.opts.outRank(int(above) + 1), nil
+}
+
+// GetPercentile 返回玩家名次超过的玩家比例（0到1），例如0.97表示排在前3%
+// 按(总人数-名次)/总人数计算，只有一名玩家时返回1；分数和总人数一次往返读取
+func (r *RedisRankingList) GetPercentile(playerID string) (_ float64, err error) {
+	defer r.opts.observeQuery("GetPercentile", time.Now(), &err)
+	var scoreCmd *redis.FloatCmd
+	var totalCmd *redis.IntCmd
+	_, err = r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		scoreCmd = pipe.ZScore(r.ctx, r.key, playerID)
+		totalCmd = pipe.ZCard(r.ctx, r.key)
+		return nil
+	})
+	if err == redis.Nil {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("获取分数失败: %w", err)
+	}
+
+	above, err := r.countAbove(r.GetRealScore(scoreCmd.Val()))
+	if err != nil {
+		return 0, err
+	}
+	return percentile(int(above)+1, totalCmd.Val()), nil
+}
+
+// GetScore 查询玩家当前分数，只需一次ZSCORE
+func (r *RedisRankingList) GetScore(playerID string) (score int64, err error) {
+	defer r.opts.observeQuery("GetScore", time.Now(), &err)
+	var composite float64
+	err = r.retry(func() (err error) {
+		composite, err = r.client.ZScore(r.ctx, r.key, playerID).Result()
+		return err
+	})
+	if err == redis.Nil {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("获取分数失败: %w", err)
+	}
+	return r.GetRealScore(composite), nil
+}
+
+// Exists 玩家是否在榜，玩家不存在不视为错误
+func (r *RedisRankingList) Exists(playerID string) (present bool, err error) {
+	defer r.opts.observeQuery("Exists", time.Now(), &err)
+	err = r.retry(func() error {
+		return r.client.ZScore(r.ctx, r.key, playerID).Err()
+	})
+	if err == redis.Nil {
+		return false, nil
+	}
 	if err != nil {
-		return 0, 0, fmt.Errorf("获取总人数失败: %v", err)
+		return false, fmt.Errorf("检查玩家失败: %w", err)
 	}
+	return true, nil
+}
 
-	// 计算实际排名（降序）
-	actualRank := int(total - rank)
-	realScore := r.GetRealScore(score)
+// GetTopN 获取前N名玩家的分数和名次，ZREVRANGE直接按名次顺序返回，一次往返
+func (r *RedisRankingList) GetTopN(n int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopN", time.Now(), &err)
+	return r.topN(n)
+}
 
-	return actualRank, realScore, nil
+// topN 同GetTopN，不上报监控，供其他已上报的方法内部调用
+func (r *RedisRankingList) topN(n int) ([]PlayerRank, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+	return r.rankPage(0, n)
 }
 
-// GetTopN 获取前N名玩家的分数和名次
-func (r *RedisRankingList) GetTopN(n int) ([]PlayerRank, error) {
+// GetTopNApprox 获取前N名，Rank为按位置的1..n，同分玩家不合并为并列名次，用于百万级榜单不关心并列的展示
+// 与GetTopN的区别只在名次：GetTopN中同分玩家名次相同，这里依次递增；顺序和分数两者一致
+// 直接按ZREVRANGE的结果逐个解码，不比较相邻分数，配置WithMetadata时同样读取元数据
+func (r *RedisRankingList) GetTopNApprox(n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopNApprox", time.Now(), &err)
 	if n <= 0 {
-		return nil, fmt.Errorf("n必须大于0")
+		return nil, ErrInvalidN
 	}
 
-	// ZRange返回升序排列，我们取前n个就是分数最高的n个
-	results, err := r.client.ZRangeWithScores(r.ctx, r.key, 0, int64(n-1)).Result()
+	var results []redis.Z
+	err = r.retry(func() (err error) {
+		results, err = r.client.ZRevRangeWithScores(r.ctx, r.key, 0, int64(n-1)).Result()
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("获取前N名失败: %v", err)
+		return nil, fmt.Errorf("获取排行榜失败: %w", err)
 	}
 
 	rankings := make([]PlayerRank, 0, len(results))
-	for i, z := range results {
+	for _, z := range results {
 		playerID, ok := z.Member.(string)
 		if !ok {
 			continue
 		}
-
-		// 处理并列排名
-		rank := i + 1
-		if i > 0 && r.GetRealScore(results[i].Score) == r.GetRealScore(results[i-1].Score) {
-			rank = rankings[i-1].Rank
-		}
-
 		rankings = append(rankings, PlayerRank{
 			PlayerID: playerID,
 			Score:    r.GetRealScore(z.Score),
-			Rank:     rank,
+			Rank:     len(rankings) + 1,
 		})
 	}
+	return r.attachMeta(r.opts.outRanks(rankings))
+}
 
-	return rankings, nil
+// GetTopNMap 以玩家ID为键返回前N名，便于O(1)判断玩家是否在前N名及其名次
+// map不保留顺序，需要顺序时使用GetTopN
+func (r *RedisRankingList) GetTopNMap(n int) (_ map[string]PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopNMap", time.Now(), &err)
+	rankings, err := r.topN(n)
+	if err != nil {
+		return nil, err
+	}
+	return rankingsMap(rankings), nil
 }
 
-// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
-func (r *RedisRankingList) GetPlayerRankRange(playerID string, n int) ([]PlayerRank, error) {
-	if n <= 0 {
-		return nil, fmt.Errorf("n必须大于0")
+// rankingsMap 把排名列表转换为以玩家ID为键的map
+func rankingsMap(rankings []PlayerRank) map[string]PlayerRank {
+	m := make(map[string]PlayerRank, len(rankings))
+	for _, pr := range rankings {
+		m[pr.PlayerID] = pr
+	}
+	return m
+}
+
+// GetTopNWithETag 获取前N名及对应的ETag，ETag由结果内容的校验和生成，配置WithMetadata时包含元数据
+func (r *RedisRankingList) GetTopNWithETag(n int) (_ []PlayerRank, _ string, err error) {
+	defer r.opts.observeQuery("GetTopNWithETag", time.Now(), &err)
+	rankings, err := r.topN(n)
+	if err != nil {
+		return nil, "", err
 	}
+	return rankings, rankingsETag(rankings), nil
+}
 
-	// 获取玩家当前排名（升序）
-	rank, err := r.client.ZRank(r.ctx, r.key, playerID).Result()
+// GetTopNIfChanged ETag与当前一致时返回false和nil切片，调用方可直接响应304
+// 仍需从Redis取前N名计算校验和，省下的是序列化和传输给客户端的开销
+func (r *RedisRankingList) GetTopNIfChanged(n int, etag string) (_ []PlayerRank, _ string, _ bool, err error) {
+	defer r.opts.observeQuery("GetTopNIfChanged", time.Now(), &err)
+	rankings, err := r.topN(n)
 	if err != nil {
-		return nil, fmt.Errorf("获取玩家排名失败: %v", err)
+		return nil, "", false, err
+	}
+	current := rankingsETag(rankings)
+	if current == etag {
+		return nil, current, false, nil
+	}
+	return rankings, current, true, nil
+}
+
+// rankingsETag 计算排名列表的校验和，元数据按键排序后计入
+func rankingsETag(rankings []PlayerRank) string {
+	h := fnv.New64a()
+	for _, pr := range rankings {
+		fmt.Fprintf(h, "%s:%d:%d", pr.PlayerID, pr.Score, pr.Rank)
+		for _, k := range pr.Meta.keys() {
+			fmt.Fprintf(h, ":%q=%q", k, pr.Meta[k])
+		}
+		h.Write([]byte{';'})
 	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
 
-	// 计算需要查询的范围
-	half := n / 2
-	start := rank - int64(half)
-	if start < 0 {
-		start = 0
+// GetTopNTieBreak 获取前N名及同分时实际比较的值，用于排查同分先后的争议，比较规则见TieBreakEntry
+func (r *RedisRankingList) GetTopNTieBreak(n int) (_ []TieBreakEntry, err error) {
+	defer r.opts.observeQuery("GetTopNTieBreak", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
 	}
-	end := start + int64(n) - 1
 
-	// 获取范围内的玩家
-	results, err := r.client.ZRangeWithScores(r.ctx, r.key, start, end).Result()
+	results, err := r.client.ZRevRangeWithScores(r.ctx, r.key, 0, int64(n-1)).Result()
 	if err != nil {
-		return nil, fmt.Errorf("获取周围玩家失败: %v", err)
+		return nil, fmt.Errorf("获取前N名失败: %w", err)
 	}
 
-	// 转换为PlayerRank列表
-	rankings := make([]PlayerRank, 0, len(results))
-	for _, z := range results {
+	entries := make([]TieBreakEntry, 0, len(results))
+	for i, z := range results {
 		playerID, ok := z.Member.(string)
 		if !ok {
 			continue
 		}
 
-		// 获取该玩家的实际排名（降序）
-		playerAscRank, err := r.client.ZRank(r.ctx, r.key, playerID).Result()
-		if err != nil {
-			continue
+		score, tieBreak := r.codec.split(z.Score)
+		score = r.opts.orient(score)
+		rank := i + 1
+		if k := len(entries); k > 0 && entries[k-1].Score == score {
+			rank = entries[k-1].Rank
+		} else if k > 0 {
+			rank = r.opts.nextRank(entries[k-1].Rank, rank)
 		}
 
-		total, err := r.client.ZCard(r.ctx, r.key).Result()
-		if err != nil {
-			return nil, fmt.Errorf("获取总人数失败: %v", err)
+		entry := TieBreakEntry{
+			PlayerRank: PlayerRank{
+				PlayerID: playerID,
+				Score:    score,
+				Rank:     rank,
+			},
+		}
+		if r.codec.secondary {
+			entry.Tiebreak = r.codec.secondaryValue(tieBreak)
+		} else {
+			entry.TieBreak = r.codec.tieBreakTime(tieBreak)
+		}
+		entries = append(entries, entry)
+	}
+	for i := range entries {
+		entries[i].Rank = r.opts.outRank(entries[i].Rank)
+	}
+
+	return entries, nil
+}
+
+// GetRankPage 获取从offset（从0开始）起的limit名玩家，名次按全榜并列规则计算
+// 会多取页首前一名玩家，若页首与其同分则统计更高分人数得到正确的并列名次
+func (r *RedisRankingList) GetRankPage(offset, limit int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankPage", time.Now(), &err)
+	return r.rankPage(offset, limit)
+}
+
+// rankPage 同GetRankPage，不上报监控，供其他已上报的方法内部调用
+func (r *RedisRankingList) rankPage(offset, limit int) ([]PlayerRank, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: offset不能小于0", ErrInvalidRange)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit必须大于0", ErrInvalidRange)
+	}
+
+	start := int64(offset)
+	if offset > 0 {
+		start--
+	}
+	var results []redis.Z
+	err := r.retry(func() (err error) {
+		results, err = r.client.ZRevRangeWithScores(r.ctx, r.key, start, int64(offset+limit-1)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("获取分页失败: %w", err)
+	}
+
+	// 拆出页首前一名作为并列判断的锚点
+	var anchor *redis.Z
+	if offset > 0 && len(results) > 0 {
+		anchor = &results[0]
+		results = results[1:]
+	}
+
+	rankings := make([]PlayerRank, 0, len(results))
+	for i, z := range results {
+		playerID, ok := z.Member.(string)
+		if !ok {
+			continue
 		}
 
-		actualRank := int(total - playerAscRank)
+		score := r.GetRealScore(z.Score)
+		rank := offset + i + 1
+		if n := len(rankings); n > 0 && rankings[n-1].Score == score {
+			rank = rankings[n-1].Rank
+		} else if n > 0 {
+			rank = r.opts.nextRank(rankings[n-1].Rank, rank)
+		} else if anchor != nil && (r.opts.rankMode == DenseRanking || r.GetRealScore(anchor.Score) == score) {
+			// 页首延续上一页的并列，名次为更高分人数+1；密集排名时页首总要统计更高的分数档
+			above, err := r.rankAbove(score)
+			if err != nil {
+				return nil, err
+			}
+			rank = int(above) + 1
+		}
 
 		rankings = append(rankings, PlayerRank{
 			PlayerID: playerID,
-			Score:    r.GetRealScore(z.Score),
-			Rank:     actualRank,
+			Score:    score,
+			Rank:     rank,
 		})
 	}
 
-	// 按排名排序（确保顺序正确）
-	sort.Slice(rankings, func(i, j int) bool {
-		return rankings[i].Rank < rankings[j].Rank
+	return r.attachMeta(r.opts.outRanks(rankings))
+}
+
+// GetRankRange 获取第startRank到第endRank位（从1开始，含两端）的玩家，按位置而非并列名次截取
+// endRank超过总人数时返回到最后一名为止，startRank超过总人数时返回空列表
+func (r *RedisRankingList) GetRankRange(startRank, endRank int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankRange", time.Now(), &err)
+	if startRank < 1 {
+		return nil, fmt.Errorf("%w: startRank不能小于1", ErrInvalidRange)
+	}
+	if endRank < startRank {
+		return nil, fmt.Errorf("%w: endRank不能小于startRank", ErrInvalidRange)
+	}
+	return r.rankPage(startRank-1, endRank-startRank+1)
+}
+
+// GetBottomN 获取最后N名玩家，按名次从前到后排列，名次为全榜名次
+// 总人数和末尾N名在同一个管道中读取，首行用更高分人数计算并列名次
+func (r *RedisRankingList) GetBottomN(n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetBottomN", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	var card *redis.IntCmd
+	var tail *redis.ZSliceCmd
+	_, err = r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		card = pipe.ZCard(r.ctx, r.key)
+		tail = pipe.ZRevRangeWithScores(r.ctx, r.key, int64(-n), -1)
+		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("获取末尾玩家失败: %w", err)
+	}
 
-	return rankings, nil
+	results := tail.Val()
+	offset := int(card.Val()) - len(results)
+	rankings := make([]PlayerRank, 0, len(results))
+	for i, z := range results {
+		playerID, ok := z.Member.(string)
+		if !ok {
+			continue
+		}
+
+		score := r.GetRealScore(z.Score)
+		rank := offset + i + 1
+		if last := len(rankings) - 1; last >= 0 && rankings[last].Score == score {
+			rank = rankings[last].Rank
+		} else if last >= 0 {
+			rank = r.opts.nextRank(rankings[last].Rank, rank)
+		} else {
+			above, err := r.rankAbove(score)
+			if err != nil {
+				return nil, err
+			}
+			rank = int(above) + 1
+		}
+
+		rankings = append(rankings, PlayerRank{
+			PlayerID: playerID,
+			Score:    score,
+			Rank:     rank,
+		})
+	}
+
+	return r.attachMeta(r.opts.outRanks(rankings))
 }
 
+// RankRangeByPercentile 获取百分位区间[lowPct, highPct)内的玩家，名次为全榜名次
+// 例如0和0.05表示前5%的玩家
+func (r *RedisRankingList) RankRangeByPercentile(lowPct, highPct float64) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("RankRangeByPercentile", time.Now(), &err)
+	if lowPct < 0 || lowPct >= highPct || highPct > 1 {
+		return nil, fmt.Errorf("%w: 百分位区间无效: %v-%v", ErrInvalidRange, lowPct, highPct)
+	}
+
+	total, err := r.client.ZCard(r.ctx, r.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取总人数失败: %w", err)
+	}
+
+	start, end := percentileOffsets(lowPct, highPct, int(total))
+	if start >= end {
+		return []PlayerRank{}, nil
+	}
+	return r.rankPage(start, end-start)
+}
+
+// countAbove 统计成绩严格好于真实分数score的玩家数，降序时即分数更高的玩家
+func (r *RedisRankingList) countAbove(score int64) (int64, error) {
+	count, err := r.client.ZCount(r.ctx, r.key, r.aheadBound(score), "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("统计高分人数失败: %w", err)
+	}
+	return count, nil
+}
+
+// rankAbove 名次排在真实分数score之前的名次数：默认为成绩更好的人数，DenseRanking时为更好的不同分数个数
+func (r *RedisRankingList) rankAbove(score int64) (int64, error) {
+	if r.opts.rankMode != DenseRanking {
+		return r.countAbove(score)
+	}
+	count, err := distinctAbove.Run(r.ctx, r.client, []string{r.key}, r.opts.orient(score), r.codec.unit()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("统计高分档位失败: %w", err)
+	}
+	return count, nil
+}
+
+// aheadBound 排在真实分数score之前的复合分数下界，用作ZCOUNT的min
+func (r *RedisRankingList) aheadBound(score int64) string {
+	return r.codec.aboveBound(r.opts.orient(score))
+}
+
+// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己）
+// n<=0时使用配置的默认窗口大小；玩家前面取(n-1)/2名（向下取整），其余在后面，n为偶数时后面多一名；
+// 靠近榜首或榜尾时窗口整体平移，总是返回min(n, 总人数)名
+// 共两次往返：ZREVRANK和ZCARD，再取整个窗口，Rank为全榜名次
+func (r *RedisRankingList) GetPlayerRankRange(playerID string, n int) (rankings []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayerRankRange", time.Now(), &err)
+	if n <= 0 {
+		n = r.opts.defaultWindow
+	}
+	return r.playerRankRange(playerID, n)
+}
+
+// GetPlayerRankRangeStrict 同GetPlayerRankRange，但n<=0时返回错误
+func (r *RedisRankingList) GetPlayerRankRangeStrict(playerID string, n int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayerRankRangeStrict", time.Now(), &err)
+	return r.playerRankRange(playerID, n)
+}
+
+// playerRankRange 同GetPlayerRankRangeStrict，不上报监控
+func (r *RedisRankingList) playerRankRange(playerID string, n int) ([]PlayerRank, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	// 第一次往返：玩家下标和总人数
+	var indexCmd *redis.IntCmd
+	var totalCmd *redis.IntCmd
+	err := r.retry(func() error {
+		_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			indexCmd = pipe.ZRevRank(r.ctx, r.key, playerID)
+			totalCmd = pipe.ZCard(r.ctx, r.key)
+			return nil
+		})
+		return err
+	})
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取玩家排名失败: %w", err)
+	}
+
+	// 计算需要查询的范围，规则与内存排行榜相同
+	start, end := rankWindow(int(indexCmd.Val()), int(totalCmd.Val()), n)
+
+	// 第二次往返：整个窗口，名次按位置推算，窗口从并列中间开始时才多一次ZCOUNT
+	rankings, err := r.rankPage(start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rankings {
+		rankings[i].Position = i + 1
+	}
+	return rankings, nil
+}
+
+// RankBandScores 返回名次区间[startRank, endRank]首尾两名的分数，用于描述奖励档位的分数边界
+// endRank超出总人数时按最后一名计算
+func (r *RedisRankingList) RankBandScores(startRank, endRank int) (highScore, lowScore int64, err error) {
+	defer r.opts.observeQuery("RankBandScores", time.Now(), &err)
+	if startRank < 1 || endRank < startRank {
+		return 0, 0, fmt.Errorf("%w: 名次区间无效: %d-%d", ErrInvalidRange, startRank, endRank)
+	}
+
+	// 一次往返取区间首尾两名，顺带取最后一名用于endRank越界时兜底
+	pipe := r.client.Pipeline()
+	highCmd := pipe.ZRevRangeWithScores(r.ctx, r.key, int64(startRank-1), int64(startRank-1))
+	lowCmd := pipe.ZRevRangeWithScores(r.ctx, r.key, int64(endRank-1), int64(endRank-1))
+	lastCmd := pipe.ZRevRangeWithScores(r.ctx, r.key, -1, -1)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return 0, 0, fmt.Errorf("获取名次区间分数失败: %w", err)
+	}
+
+	high := highCmd.Val()
+	if len(high) == 0 {
+		return 0, 0, fmt.Errorf("%w: 名次%d超出排行榜人数", ErrNotEnoughPlayers, startRank)
+	}
+	low := lowCmd.Val()
+	if len(low) == 0 {
+		low = lastCmd.Val()
+	}
+
+	return r.GetRealScore(high[0].Score), r.GetRealScore(low[0].Score), nil
+}
+
+// CountInScoreRange 统计真实分数在[minScore, maxScore]内的玩家数，两端都包含
+// 同一真实分数对应一段复合分数，因此区间换算为[minScore的最小复合分数, maxScore+1的最小复合分数)
+func (r *RedisRankingList) CountInScoreRange(minScore, maxScore int64) (_ int64, err error) {
+	defer r.opts.observeQuery("CountInScoreRange", time.Now(), &err)
+	if minScore > maxScore {
+		return 0, fmt.Errorf("%w: 分数区间无效: %d-%d", ErrInvalidRange, minScore, maxScore)
+	}
+
+	min, max := r.codec.rangeBounds(r.opts.keyRange(minScore, maxScore))
+	count, err := r.client.ZCount(r.ctx, r.key, min, max).Result()
+	if err != nil {
+		return 0, fmt.Errorf("统计分数区间人数失败: %w", err)
+	}
+	return count, nil
+}
+
+// ScoreHistogram 按分桶边界统计真实分数分布，边界需严格升序，返回len(buckets)+1个计数
+// 第i个计数（i从1开始）为分数在[buckets[i-1], buckets[i])内的人数，第0个为低于buckets[0]，最后一个为不低于最后一个边界
+// 每个分桶一条ZCOUNT，通过一次管道完成
+func (r *RedisRankingList) ScoreHistogram(buckets []int64) (_ []int64, err error) {
+	defer r.opts.observeQuery("ScoreHistogram", time.Now(), &err)
+	ranges, ok := histogramRanges(buckets)
+	if !ok {
+		return nil, fmt.Errorf("%w: 分桶边界不能为空且必须严格升序", ErrInvalidRange)
+	}
+
+	cmds := make([]*redis.IntCmd, len(ranges))
+	pipe := r.client.Pipeline()
+	for i, rg := range ranges {
+		if rg[0] > rg[1] {
+			continue
+		}
+		min, max := r.codec.rangeBounds(r.opts.keyRange(rg[0], rg[1]))
+		cmds[i] = pipe.ZCount(r.ctx, r.key, min, max)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("统计分数分布失败: %w", err)
+	}
+
+	counts := make([]int64, len(ranges))
+	for i, cmd := range cmds {
+		if cmd != nil {
+			counts[i] = cmd.Val()
+		}
+	}
+	return counts, nil
+}
+
+// AverageScore 全榜平均分，需要分批读取全榜，排行榜为空时返回ErrNotEnoughPlayers
+func (r *RedisRankingList) AverageScore() (_ float64, err error) {
+	defer r.opts.observeQuery("AverageScore", time.Now(), &err)
+	var sum float64
+	var total int64
+	err = r.forEachRecord(func(rec PlayerRecord) error {
+		sum += float64(rec.Score)
+		total++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		return 0, ErrNotEnoughPlayers
+	}
+	return sum / float64(total), nil
+}
+
+// MedianScore 全榜分数的中位数，人数为偶数时取中间两名的平均值，排行榜为空时返回ErrNotEnoughPlayers
+// 先ZCARD再取中间位置，两次往返之间有写入时结果可能偏差一名
+func (r *RedisRankingList) MedianScore() (_ float64, err error) {
+	defer r.opts.observeQuery("MedianScore", time.Now(), &err)
+	total, err := r.client.ZCard(r.ctx, r.key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("获取总人数失败: %w", err)
+	}
+	if total == 0 {
+		return 0, ErrNotEnoughPlayers
+	}
+
+	results, err := r.client.ZRevRangeWithScores(r.ctx, r.key, (total-1)/2, total/2).Result()
+	if err != nil {
+		return 0, fmt.Errorf("获取中位数失败: %w", err)
+	}
+	if len(results) == 0 {
+		return 0, ErrNotEnoughPlayers
+	}
+	a := r.GetRealScore(results[0].Score)
+	b := r.GetRealScore(results[len(results)-1].Score)
+	return (float64(a) + float64(b)) / 2, nil
+}
+
+// GetPlayersInScoreRange 获取真实分数在[minScore, maxScore]内的玩家，按名次排列，最多limit名，名次为全榜名次
+// 区间内的玩家在全榜中连续排列，第一名之前正好是分数高于maxScore的玩家，与区间查询在同一个管道中统计
+func (r *RedisRankingList) GetPlayersInScoreRange(minScore, maxScore int64, limit int) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetPlayersInScoreRange", time.Now(), &err)
+	if minScore > maxScore {
+		return nil, fmt.Errorf("%w: 分数区间无效: %d-%d", ErrInvalidRange, minScore, maxScore)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit必须大于0", ErrInvalidRange)
+	}
+
+	lo, hi := r.opts.keyRange(minScore, maxScore)
+	min, max := r.codec.rangeBounds(lo, hi)
+	var above func() int64
+	var players *redis.ZSliceCmd
+	_, err = r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		above = r.queueRankAbove(pipe, hi)
+		players = pipe.ZRevRangeByScoreWithScores(r.ctx, r.key, &redis.ZRangeBy{
+			Min:   min,
+			Max:   max,
+			Count: int64(limit),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("获取分数区间玩家失败: %w", err)
+	}
+
+	offset := int(above())
+	results := players.Val()
+	rankings := make([]PlayerRank, 0, len(results))
+	for i, z := range results {
+		playerID, ok := z.Member.(string)
+		if !ok {
+			continue
+		}
+
+		score := r.GetRealScore(z.Score)
+		rank := offset + i + 1
+		if last := len(rankings) - 1; last >= 0 && rankings[last].Score == score {
+			rank = rankings[last].Rank
+		} else if last >= 0 {
+			rank = r.opts.nextRank(rankings[last].Rank, rank)
+		}
+
+		rankings = append(rankings, PlayerRank{
+			PlayerID: playerID,
+			Score:    score,
+			Rank:     rank,
+		})
+	}
+
+	return r.attachMeta(r.opts.outRanks(rankings))
+}
+
+// CutoffScore 返回当前第n名玩家的分数，即进入前n名所需的最低分数
+// 人数不足n时返回ErrNotEnoughPlayers
+func (r *RedisRankingList) CutoffScore(n int) (_ int64, err error) {
+	defer r.opts.observeQuery("CutoffScore", time.Now(), &err)
+	if n <= 0 {
+		return 0, ErrInvalidN
+	}
+
+	results, err := r.client.ZRevRangeWithScores(r.ctx, r.key, int64(n-1), int64(n-1)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("获取第%d名失败: %w", n, err)
+	}
+	if len(results) == 0 {
+		return 0, fmt.Errorf("%w: 不足%d人", ErrNotEnoughPlayers, n)
+	}
+	return r.GetRealScore(results[0].Score), nil
+}
+
+// GetScoreRankForList 按输入顺序返回每个玩家的分数和名次
+// 结果与playerIDs逐位对应，不去重，同一ID出现两次会得到两行相同结果
+// 不存在的玩家对应行只填PlayerID，Rank为0，配置WithZeroBasedRanks时为-1
+func (r *RedisRankingList) GetScoreRankForList(playerIDs []string) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetScoreRankForList", time.Now(), &err)
+	ranks, err := r.ranks(playerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PlayerRank, 0, len(playerIDs))
+	for _, id := range playerIDs {
+		if pr, ok := ranks[id]; ok {
+			result = append(result, pr)
+		} else {
+			result = append(result, PlayerRank{PlayerID: id, Rank: r.opts.outRank(0)})
+		}
+	}
+	return result, nil
+}
+
+// GetRanks 批量查询玩家的分数和名次，不在榜的玩家不出现在结果中
+// 第一次往返用管道读取所有玩家的分数，第二次按不同分数统计更高分人数，名次与GetRank一致
+func (r *RedisRankingList) GetRanks(playerIDs []string) (_ map[string]PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRanks", time.Now(), &err)
+	return r.ranks(playerIDs)
+}
+
+// ranks 同GetRanks，不上报监控
+func (r *RedisRankingList) ranks(playerIDs []string) (map[string]PlayerRank, error) {
+	result := make(map[string]PlayerRank, len(playerIDs))
+	if len(playerIDs) == 0 {
+		return result, nil
+	}
+
+	// 相同ID只查询一次
+	pipe := r.client.Pipeline()
+	scores := make(map[string]*redis.FloatCmd, len(playerIDs))
+	for _, id := range playerIDs {
+		if _, ok := scores[id]; !ok {
+			scores[id] = pipe.ZScore(r.ctx, r.key, id)
+		}
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("批量获取分数失败: %w", err)
+	}
+
+	pipe = r.client.Pipeline()
+	above := make(map[int64]func() int64)
+	for id, cmd := range scores {
+		composite, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("获取分数失败: %w", err)
+		}
+
+		score := r.GetRealScore(composite)
+		result[id] = PlayerRank{PlayerID: id, Score: score}
+		if _, ok := above[score]; !ok {
+			above[score] = r.queueRankAbove(pipe, r.opts.orient(score))
+		}
+	}
+	if len(above) == 0 {
+		return result, nil
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, fmt.Errorf("统计高分人数失败: %w", err)
+	}
+
+	for id, pr := range result {
+		pr.Rank = r.opts.outRank(int(above[pr.Score]()) + 1)
+		result[id] = pr
+	}
+	return result, nil
+}
+
+// GetRankAmong 好友榜：把playerID和subset中的玩家按全榜规则排序，名次在这些玩家中重新从1计算
+// 一次管道读取所有玩家的分数后在本地排序；subset中不在榜的玩家跳过，重复ID只计一次，
+// playerID总会包含在内，不在榜时返回ErrPlayerNotFound
+func (r *RedisRankingList) GetRankAmong(playerID string, subset []string) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetRankAmong", time.Now(), &err)
+	ids := make([]string, 0, len(subset)+1)
+	cmds := make(map[string]*redis.FloatCmd, len(subset)+1)
+	pipe := r.client.Pipeline()
+	for _, id := range append([]string{playerID}, subset...) {
+		if _, ok := cmds[id]; !ok {
+			ids = append(ids, id)
+			cmds[id] = pipe.ZScore(r.ctx, r.key, id)
+		}
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("批量获取分数失败: %w", err)
+	}
+
+	players := make([]*Player, 0, len(ids))
+	for _, id := range ids {
+		composite, err := cmds[id].Result()
+		if err == redis.Nil {
+			if id == playerID {
+				return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("获取分数失败: %w", err)
+		}
+
+		players = append(players, r.decodePlayer(id, composite))
+	}
+	sortPlayersBy(players, r.opts.rankBefore())
+
+	ranks := r.opts.sortedRanks(players)
+	rankings := make([]PlayerRank, 0, len(players))
+	for i, p := range players {
+		rankings = append(rankings, PlayerRank{
+			PlayerID: p.ID,
+			Score:    r.opts.orient(p.Score),
+			Rank:     ranks[i],
+		})
+	}
+	return r.attachMeta(r.opts.outRanks(rankings))
+}
+
+// normalizeBatch Normalize每批读取的玩家数
+const normalizeBatch = 1000
+
+// Normalize 按当前排名顺序重新分配复合分数，修复浮点精度丢失导致的同分顺序错乱
+// 同分玩家的同分排序位被改写为紧凑序号，排名顺序不变，但不再是真实的更新时间
+// 在WATCH事务中执行，期间排行榜被修改时返回redis.TxFailedErr，调用方可重试
+func (r *RedisRankingList) Normalize() (err error) {
+	defer r.opts.observeUpdate("Normalize", time.Now(), &err)
+	if r.codec.secondary {
+		return errSecondaryTieBreak
+	}
+	return r.client.Watch(r.ctx, func(tx *redis.Tx) error {
+		var results []redis.Z
+		for start := int64(0); ; start += normalizeBatch {
+			batch, err := tx.ZRevRangeWithScores(r.ctx, r.key, start, start+normalizeBatch-1).Result()
+			if err != nil {
+				return fmt.Errorf("读取排行榜失败: %w", err)
+			}
+			results = append(results, batch...)
+			if len(batch) < normalizeBatch {
+				break
+			}
+		}
+
+		_, err := tx.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			var seq int64
+			for i, z := range results {
+				score := r.keyScore(z.Score)
+				if i > 0 && score != r.keyScore(results[i-1].Score) {
+					seq = 0
+				}
+				pipe.ZAdd(r.ctx, r.key, &redis.Z{
+					Score:  r.codec.encode(score, r.codec.nth(seq)),
+					Member: z.Member,
+				})
+				seq++
+			}
+			return nil
+		})
+		return err
+	}, r.key)
+}
+
+// baselineTotalField 基线哈希中记录总人数的字段，带\x00前缀避免与玩家ID冲突
+const baselineTotalField = "\x00total"
+
+// CaptureBaseline 以name保存当前全榜名次作为基线，同名基线会被覆盖
+// 基线存放在"<key>:baseline:<name>"哈希中，先写临时键再RENAME，读取方不会看到写了一半的基线
+func (r *RedisRankingList) CaptureBaseline(name string) (err error) {
+	defer r.opts.observeUpdate("CaptureBaseline", time.Now(), &err)
+	return r.captureBaseline(r.baselineKey(name))
+}
+
+// captureBaseline 把当前全榜名次保存到哈希dst
+func (r *RedisRankingList) captureBaseline(dst string) error {
+	tmp := dst + ":tmp"
+	if err := r.client.Del(r.ctx, tmp).Err(); err != nil {
+		return fmt.Errorf("清理临时基线失败: %w", err)
+	}
+
+	var total int64
+	err := r.forEachRanked(0, rankedBatch, func(batch []PlayerRank) error {
+		values := make([]interface{}, 0, len(batch)*2)
+		for _, pr := range batch {
+			values = append(values, pr.PlayerID, pr.Rank)
+		}
+		total += int64(len(batch))
+		return r.client.HSet(r.ctx, tmp, values...).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("保存基线失败: %w", err)
+	}
+
+	if err := r.client.HSet(r.ctx, tmp, baselineTotalField, total).Err(); err != nil {
+		return fmt.Errorf("保存基线失败: %w", err)
+	}
+	return r.client.Rename(r.ctx, tmp, dst).Err()
+}
+
+// RankChangeSinceBaseline 返回玩家相对基线上升的名次数，正数表示上升，负数表示下降
+// 基线中没有的玩家视为新上榜，按基线总人数+1名计算
+func (r *RedisRankingList) RankChangeSinceBaseline(name, playerID string) (delta int, err error) {
+	defer r.opts.observeQuery("RankChangeSinceBaseline", time.Now(), &err)
+	delta, err = r.rankChange(r.baselineKey(name), playerID)
+	if errors.Is(err, errBaselineMissing) {
+		return 0, fmt.Errorf("%w: %s", ErrBaselineNotFound, name)
+	}
+	return delta, err
+}
+
+// errBaselineMissing rankChange读取的基线哈希不存在
+var errBaselineMissing = errors.New("基线不存在")
+
+// rankChange 玩家相对哈希key中保存的名次上升的名次数，哈希不存在时返回errBaselineMissing
+func (r *RedisRankingList) rankChange(key, playerID string) (int, error) {
+	values, err := r.client.HMGet(r.ctx, key, baselineTotalField, playerID).Result()
+	if err != nil {
+		return 0, fmt.Errorf("获取基线失败: %w", err)
+	}
+	if values[0] == nil {
+		return 0, errBaselineMissing
+	}
+
+	total, err := strconv.Atoi(values[0].(string))
+	if err != nil {
+		return 0, fmt.Errorf("基线数据错误: %w", err)
+	}
+	oldRank := total + 1
+	if values[1] != nil {
+		if oldRank, err = strconv.Atoi(values[1].(string)); err != nil {
+			return 0, fmt.Errorf("基线数据错误: %w", err)
+		}
+	}
+
+	composite, err := r.client.ZScore(r.ctx, r.key, playerID).Result()
+	if err == redis.Nil {
+		return 0, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("获取分数失败: %w", err)
+	}
+	above, err := r.rankAbove(r.GetRealScore(composite))
+	if err != nil {
+		return 0, err
+	}
+
+	return oldRank - int(above+1), nil
+}
+
+// baselineKey 基线的存储键
+func (r *RedisRankingList) baselineKey(name string) string {
+	return r.key + ":baseline:" + name
+}
+
+// snapshotKey 名次快照的存储键，格式与基线相同
+func (r *RedisRankingList) snapshotKey(snapshotID string) string {
+	return r.key + ":snapshot:" + snapshotID
+}
+
+// snapshotSeqKey 生成快照ID的计数器，多个进程创建的快照ID也不会重复
+func (r *RedisRankingList) snapshotSeqKey() string {
+	return r.key + ":snapshot:seq"
+}
+
+// SnapshotRanks 保存当前全榜名次，返回用于RankDelta的快照ID，用于“比昨天上升了5名”这类展示
+// 快照存放在"<key>:snapshot:<ID>"哈希中，会一直保留，不再需要时调用DeleteSnapshot删除
+func (r *RedisRankingList) SnapshotRanks() (snapshotID string, err error) {
+	defer r.opts.observeUpdate("SnapshotRanks", time.Now(), &err)
+	seq, err := r.client.Incr(r.ctx, r.snapshotSeqKey()).Result()
+	if err != nil {
+		return "", fmt.Errorf("生成快照ID失败: %w", err)
+	}
+	snapshotID = strconv.FormatInt(seq, 10)
+	if err := r.captureBaseline(r.snapshotKey(snapshotID)); err != nil {
+		return "", err
+	}
+	return snapshotID, nil
+}
+
+// RankDelta 返回玩家当前名次减去快照中的名次，负数表示名次上升
+// 快照中没有的玩家视为新上榜，按快照总人数+1名计算；快照不存在时返回ErrSnapshotNotFound
+func (r *RedisRankingList) RankDelta(playerID, snapshotID string) (delta int, err error) {
+	defer r.opts.observeQuery("RankDelta", time.Now(), &err)
+	change, err := r.rankChange(r.snapshotKey(snapshotID), playerID)
+	if errors.Is(err, errBaselineMissing) {
+		return 0, fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return -change, nil
+}
+
+// DeleteSnapshot 删除名次快照，快照不存在时不视为错误
+func (r *RedisRankingList) DeleteSnapshot(snapshotID string) (err error) {
+	defer r.opts.observeUpdate("DeleteSnapshot", time.Now(), &err)
+	if err := r.client.Del(r.ctx, r.snapshotKey(snapshotID)).Err(); err != nil {
+		return fmt.Errorf("删除快照失败: %w", err)
+	}
+	return nil
+}
+
+// rankedBatch forEachRanked默认每批读取的玩家数
+const rankedBatch = 1000
+
+// errStopScan ScanAll的回调要求停止时用于结束forEachRanked
+var errStopScan = errors.New("scan stopped")
+
+// ScanAll 按名次从高到低流式遍历全榜，每批用一次ZREVRANGE读取batchSize名，batchSize<=0时每批1000名
+// fn返回false时停止；名次按并列规则计算，批与批之间不加锁，遍历期间有写入时不是同一时刻的快照
+func (r *RedisRankingList) ScanAll(batchSize int, fn func(PlayerRank) bool) (err error) {
+	defer r.opts.observeQuery("ScanAll", time.Now(), &err)
+	if batchSize <= 0 {
+		batchSize = rankedBatch
+	}
+
+	err = r.forEachRanked(0, int64(batchSize), func(batch []PlayerRank) error {
+		// batch的最后一名还要留给forEachRanked计算下一批的并列，不能原地换算
+		for _, pr := range batch {
+			pr.Rank = r.opts.outRank(pr.Rank)
+			if !fn(pr) {
+				return errStopScan
+			}
+		}
+		return nil
+	})
+	if err == errStopScan {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("遍历排行榜失败: %w", err)
+	}
+	return nil
+}
+
+// forEachRanked 按名次从高到低每批batchSize名遍历前limit名，limit<=0表示全榜，名次按并列规则计算
+// 批与批之间不加锁，遍历期间有写入时结果不是同一时刻的快照
+func (r *RedisRankingList) forEachRanked(limit, batchSize int64, fn func(batch []PlayerRank) error) error {
+	var last *PlayerRank
+	for start := int64(0); limit <= 0 || start < limit; start += batchSize {
+		stop := start + batchSize - 1
+		if limit > 0 && stop >= limit {
+			stop = limit - 1
+		}
+		results, err := r.client.ZRevRangeWithScores(r.ctx, r.key, start, stop).Result()
+		if err != nil {
+			return err
+		}
+
+		batch := make([]PlayerRank, 0, len(results))
+		for i, z := range results {
+			playerID, ok := z.Member.(string)
+			if !ok {
+				continue
+			}
+
+			pr := PlayerRank{
+				PlayerID: playerID,
+				Score:    r.GetRealScore(z.Score),
+				Rank:     int(start) + i + 1,
+			}
+			if last != nil && last.Score == pr.Score {
+				pr.Rank = last.Rank
+			} else if last != nil {
+				pr.Rank = r.opts.nextRank(last.Rank, pr.Rank)
+			}
+			batch = append(batch, pr)
+			last = &batch[len(batch)-1]
+		}
+
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+		if int64(len(results)) < stop-start+1 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// EncodeTopNJSON 把前N名以JSON数组流式写入w，逐批读取逐个编码，内存占用与N无关
+func (r *RedisRankingList) EncodeTopNJSON(w io.Writer, n int) (err error) {
+	defer r.opts.observeQuery("EncodeTopNJSON", time.Now(), &err)
+	if n <= 0 {
+		return ErrInvalidN
+	}
+
+	aw := newJSONArrayWriter(w)
+	err = r.forEachRanked(int64(n), rankedBatch, func(batch []PlayerRank) error {
+		for _, pr := range batch {
+			pr.Rank = r.opts.outRank(pr.Rank)
+			if err := aw.Write(pr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("导出前N名失败: %w", err)
+	}
+	return aw.Close()
+}
+
+// ExportTopNCSV 把前N名以CSV流式写入w，列为rank、playerID、score、updateTime，首行为表头
+// 名次和顺序与GetTopN一致；Redis排行榜不记录精确的更新时间，updateTime列为空；逐批读取，每批写完即刷新
+func (r *RedisRankingList) ExportTopNCSV(w io.Writer, n int) (err error) {
+	defer r.opts.observeQuery("ExportTopNCSV", time.Now(), &err)
+	if n <= 0 {
+		return ErrInvalidN
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err = r.forEachRanked(int64(n), rankedBatch, func(batch []PlayerRank) error {
+		for _, pr := range batch {
+			pr.Rank = r.opts.outRank(pr.Rank)
+			if err := writeCSVRow(cw, pr); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return fmt.Errorf("导出前N名失败: %w", err)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportBatch 导出和载入全榜时每批读取的玩家数，ImportJSON每批写入的玩家数
+const exportBatch = 1000
+
+// ExportJSON 把全榜按名次顺序以PlayerRecord的JSON数组流式写入w，用于备份和迁移
+// UpdateTime取自复合分数中的同分排序时间，精确到秒；分批读取，遍历期间有写入时不是同一时刻的快照
+func (r *RedisRankingList) ExportJSON(w io.Writer) (err error) {
+	defer r.opts.observeQuery("ExportJSON", time.Now(), &err)
+	aw := newJSONArrayWriter(w)
+	err = r.forEachRecord(func(rec PlayerRecord) error {
+		return aw.Write(rec)
+	})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// forEachRecord 按名次顺序分批读取全榜，把每名玩家的真实分数和同分排序时间交给fn，fn返回错误时停止
+func (r *RedisRankingList) forEachRecord(fn func(rec PlayerRecord) error) error {
+	for start := int64(0); ; start += exportBatch {
+		results, err := r.client.ZRevRangeWithScores(r.ctx, r.key, start, start+exportBatch-1).Result()
+		if err != nil {
+			return fmt.Errorf("读取排行榜失败: %w", err)
+		}
+
+		for _, z := range results {
+			playerID, ok := z.Member.(string)
+			if !ok {
+				continue
+			}
+			p := r.decodePlayer(playerID, z.Score)
+			rec := PlayerRecord{PlayerID: playerID, Score: r.opts.orient(p.Score), Tiebreak: p.Tiebreak, UpdateTime: p.UpdateTime}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(results) < exportBatch {
+			return nil
+		}
+	}
+}
+
+// LoadFromRedis 把Redis排行榜全量载入新的内存排行榜，用于读多写少的分析场景
+// 内存排行榜沿用r的配置，名次和同分先后与Redis一致，更新时间精确到秒；
+// 分批读取，载入期间有写入时不是同一时刻的快照，同一玩家出现多次时以最后读到的为准
+func LoadFromRedis(r *RedisRankingList) (*RankingSystem, error) {
+	rs := &RankingSystem{
+		players:   make(map[string]*Player),
+		ranks:     r.opts.newRankList(),
+		opts:      r.opts,
+		baselines: make(map[string]*baseline),
+	}
+
+	err := r.forEachRecord(func(rec PlayerRecord) error {
+		if p, exists := rs.players[rec.PlayerID]; exists {
+			rs.ranks.remove(p)
+		}
+		rs.addPlayer(&Player{
+			ID:         rec.PlayerID,
+			Score:      rs.opts.orient(rec.Score),
+			Tiebreak:   rec.Tiebreak,
+			UpdateTime: rec.UpdateTime,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// ImportJSON 读取ExportJSON的输出，按原分数和同分排序时间重新编码复合分数，同分先后与导出时一致
+// 分数不经过ScoreQuantizer，任一分数超出范围时不写入任何数据
+// ImportReplace先写入临时键再RENAME覆盖排行榜，读取方不会看到导入一半的榜；墓碑不受影响
+func (r *RedisRankingList) ImportJSON(rd io.Reader, mode ImportMode) (err error) {
+	defer r.opts.observeUpdate("ImportJSON", time.Now(), &err)
+	records, err := readPlayerRecords(rd)
+	if err != nil {
+		return fmt.Errorf("解析导入数据失败: %w", err)
+	}
+
+	members := make([]*redis.Z, 0, len(records))
+	for _, rec := range records {
+		score := r.opts.orient(rec.Score)
+		if err := r.codec.check(score); err != nil {
+			return fmt.Errorf("玩家%s: %w", rec.PlayerID, err)
+		}
+		tieBreak := r.codec.tieBreakOf(rec.UpdateTime)
+		if r.codec.secondary {
+			if tieBreak, err = r.codec.secondaryOf(rec.Tiebreak); err != nil {
+				return fmt.Errorf("玩家%s: %w", rec.PlayerID, err)
+			}
+		}
+		members = append(members, &redis.Z{
+			Score:  r.codec.encode(score, tieBreak),
+			Member: rec.PlayerID,
+		})
+	}
+
+	dst := r.key
+	if mode == ImportReplace {
+		dst = r.key + ":import"
+		if err := r.client.Del(r.ctx, dst).Err(); err != nil {
+			return fmt.Errorf("清理临时键失败: %w", err)
+		}
+	}
+	for start := 0; start < len(members); start += exportBatch {
+		end := min(len(members), start+exportBatch)
+		if err := r.client.ZAdd(r.ctx, dst, members[start:end]...).Err(); err != nil {
+			return fmt.Errorf("导入排行榜失败: %w", err)
+		}
+	}
+	if mode != ImportReplace {
+		return nil
+	}
+
+	// 没有玩家时临时键不存在，直接删除原榜
+	if len(members) == 0 {
+		if err := r.client.Del(r.ctx, r.key).Err(); err != nil {
+			return fmt.Errorf("导入排行榜失败: %w", err)
+		}
+		return nil
+	}
+	if err := r.client.Rename(r.ctx, dst, r.key).Err(); err != nil {
+		return fmt.Errorf("导入排行榜失败: %w", err)
+	}
+	return nil
+}
+
+// ForEach 从cursor开始用ZSCAN遍历排行榜，count为每次扫描的建议数量，首次调用cursor传0
+// fn返回false时停止，返回下次继续使用的游标，done表示已遍历完
+// 调用方可把游标持久化，导出中断后从该游标继续；中途停止时返回本批的游标，续扫会重复本批部分成员
+// 遍历期间的并发写入遵循ZSCAN的语义：全程在榜的成员至少返回一次，可能重复，期间增删的成员不保证返回
+// ZSCAN无序，返回的PlayerRank不含名次
+func (r *RedisRankingList) ForEach(cursor uint64, count int64, fn func(PlayerRank) bool) (next uint64, done bool, err error) {
+	defer r.opts.observeQuery("ForEach", time.Now(), &err)
+	return r.scanComposites(cursor, count, func(playerID string, composite float64) bool {
+		return fn(PlayerRank{PlayerID: playerID, Score: r.GetRealScore(composite)})
+	})
+}
+
+// scanComposites 用ZSCAN遍历成员及其复合分数，语义同ForEach
+func (r *RedisRankingList) scanComposites(cursor uint64, count int64, fn func(playerID string, composite float64) bool) (next uint64, done bool, err error) {
+	for {
+		keys, nextCursor, err := r.client.ZScan(r.ctx, r.key, cursor, "", count).Result()
+		if err != nil {
+			return cursor, false, fmt.Errorf("遍历排行榜失败: %w", err)
+		}
+
+		// ZSCAN结果为成员和分数交替排列
+		for i := 0; i+1 < len(keys); i += 2 {
+			composite, err := strconv.ParseFloat(keys[i+1], 64)
+			if err != nil {
+				return cursor, false, fmt.Errorf("解析分数失败: %w", err)
+			}
+			if !fn(keys[i], composite) {
+				return cursor, false, nil
+			}
+		}
+
+		if nextCursor == 0 {
+			return 0, true, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// windowScanCount GetTopNInTimeWindow每次ZSCAN的建议数量
+const windowScanCount = 1000
+
+// GetTopNInTimeWindow 获取最后更新时间在[since, until]内的前N名
+// 用ZSCAN流式遍历并维护大小为N的最小堆，不需要取出并排序整个排行榜，适合活跃玩家远少于总人数的场景
+// 名次为在筛选结果中的名次，不是全榜名次
+func (r *RedisRankingList) GetTopNInTimeWindow(n int, since, until time.Time) (_ []PlayerRank, err error) {
+	defer r.opts.observeQuery("GetTopNInTimeWindow", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	if r.codec.secondary {
+		return nil, errSecondaryTieBreak
+	}
+
+	from, to := r.codec.tieBreakOf(since), r.codec.tieBreakOf(until)
+	h := newTopKHeap(n, r.opts.rankBefore())
+	_, _, err = r.scanComposites(0, windowScanCount, func(playerID string, composite float64) bool {
+		score, tieBreak := r.codec.split(composite)
+		if tieBreak < from || tieBreak > to {
+			return true
+		}
+
+		e := &topKEntry{player: Player{ID: playerID, Score: score, UpdateTime: r.codec.tieBreakTime(tieBreak)}}
+		if h.Len() < n {
+			heap.Push(h, e)
+		} else if h.better(e, h.entries[0]) {
+			h.replaceTop(e)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	players := make([]*Player, 0, h.Len())
+	for _, e := range h.entries {
+		players = append(players, &e.player)
+	}
+	sortPlayersBy(players, r.opts.rankBefore())
+
+	rankings := make([]PlayerRank, 0, len(players))
+	for i, p := range players {
+		rank := i + 1
+		if i > 0 && p.Score == players[i-1].Score {
+			rank = rankings[i-1].Rank
+		} else if i > 0 {
+			rank = r.opts.nextRank(rankings[i-1].Rank, rank)
+		}
+		rankings = append(rankings, PlayerRank{
+			PlayerID: p.ID,
+			Score:    r.opts.orient(p.Score),
+			Rank:     rank,
+		})
+	}
+
+	return r.opts.outRanks(rankings), nil
+}
+
+// pruneBatch PruneInactive每次ZSCAN的建议数量及每批删除的玩家数
+const pruneBatch = 1000
+
+// PruneInactive 删除最后更新时间早于olderThan之前的玩家，返回删除人数
+// 更新时间取自复合分数的同分排序位，精确到秒；Normalize会改写这部分，Normalize之后不应再按时间清理
+// 先ZSCAN找出过期玩家，再按批用Lua脚本删除复合分数未变化的玩家，遍历期间重新提交过分数的玩家不会被误删
+func (r *RedisRankingList) PruneInactive(olderThan time.Duration) (removed int, err error) {
+	defer r.opts.observeUpdate("PruneInactive", time.Now(), &err)
+	if r.codec.secondary {
+		return 0, errSecondaryTieBreak
+	}
+	cutoff := r.codec.tieBreakOf(r.opts.now().Add(-olderThan))
+
+	var stale []interface{}
+	_, _, err = r.scanComposites(0, pruneBatch, func(playerID string, composite float64) bool {
+		if _, tieBreak := r.codec.split(composite); tieBreak < cutoff {
+			stale = append(stale, playerID, composite)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(stale); start += 2 * pruneBatch {
+		end := min(len(stale), start+2*pruneBatch)
+		n, err := zremUnchanged.Run(r.ctx, r.client, []string{r.key}, stale[start:end]...).Int()
+		if err != nil {
+			return removed, fmt.Errorf("清理不活跃玩家失败: %w", err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// zremUnchanged 删除复合分数仍等于给定值的玩家，返回删除人数
+// KEYS[1] 排行榜键  ARGV 玩家ID和复合分数交替排列
+var zremUnchanged = redis.NewScript(`
+local removed = 0
+for i = 1, #ARGV, 2 do
+	local composite = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if composite and tonumber(composite) == tonumber(ARGV[i + 1]) then
+		removed = removed + redis.call('ZREM', KEYS[1], ARGV[i])
+	end
+end
+return removed
+`)
+
 // GetTotalPlayers 获取总玩家数
-func (r *RedisRankingList) GetTotalPlayers() (int64, error) {
-	return r.client.ZCard(r.ctx, r.key).Result()
+func (r *RedisRankingList) GetTotalPlayers() (total int64, err error) {
+	defer r.opts.observeQuery("GetTotalPlayers", time.Now(), &err)
+	err = r.retry(func() error {
+		total, err = r.client.ZCard(r.ctx, r.key).Result()
+		return err
+	})
+	return total, err
+}
+
+// IsEmpty 排行榜是否没有任何玩家，ZSet为空时键不存在，用EXISTS即可判断
+func (r *RedisRankingList) IsEmpty() (_ bool, err error) {
+	defer r.opts.observeQuery("IsEmpty", time.Now(), &err)
+	n, err := r.client.Exists(r.ctx, r.key).Result()
+	if err != nil {
+		return false, fmt.Errorf("检查排行榜失败: %w", err)
+	}
+	return n == 0, nil
+}
+
+// Clear 清空排行榜，用于赛季重置，同时删除墓碑和玩家元数据，排名基线保留
+// 一条DEL完成，其他客户端不会看到清空一半的榜
+func (r *RedisRankingList) Clear() (err error) {
+	defer r.opts.observeUpdate("Clear", time.Now(), &err)
+	return r.clear()
+}
+
+// clear 同Clear，不上报监控
+func (r *RedisRankingList) clear() error {
+	if err := r.client.Del(r.ctx, append(r.tombstoneKeys(), r.metaKey())...).Err(); err != nil {
+		return fmt.Errorf("清空排行榜失败: %w", err)
+	}
+	return nil
+}
+
+// Reset 同Clear
+func (r *RedisRankingList) Reset() (err error) {
+	defer r.opts.observeUpdate("Reset", time.Now(), &err)
+	return r.clear()
+}
+
+// ArchiveSeason 用RENAMENX把当前排行榜原子地改名为archiveKey，原键随之变为空榜，开始新赛季
+// archiveKey已存在时不做任何修改并返回ErrArchiveExists；墓碑不随之归档
+// 归档后可用NewRedisRankingWithClient(client, archiveKey)查询历史赛季
+func (r *RedisRankingList) ArchiveSeason(archiveKey string) (err error) {
+	defer r.opts.observeUpdate("ArchiveSeason", time.Now(), &err)
+	renamed, err := r.client.RenameNX(r.ctx, r.key, archiveKey).Result()
+	if err != nil {
+		return fmt.Errorf("归档赛季失败: %w", err)
+	}
+	if !renamed {
+		return fmt.Errorf("%w: %s", ErrArchiveExists, archiveKey)
+	}
+	return nil
+}
+
+// Merge 把r和other中同一玩家的真实分数相加写入dstKey，用于合并两个区服的排行榜，dstKey原有内容被替换
+// 只在一方出现的玩家原样保留；两边都有的玩家分数相加，同分先后取两者中更靠前的一次，默认即保留更早的更新时间，
+// 配置了WithSecondaryTieBreak时保留更大的次要排序值；元数据、墓碑和基线不合并
+// 两个排行榜须位于同一个Redis实例，且分数位数、方向和同分规则相同，否则返回错误；
+// 任一玩家的和超出可表示范围时不做任何修改并返回ErrScoreOutOfRange。dstKey可以是r或other自己的键
+func (r *RedisRankingList) Merge(other *RedisRankingList, dstKey string) (err error) {
+	defer r.opts.observeUpdate("Merge", time.Now(), &err)
+	if r.codec != other.codec || r.opts.order != other.opts.order {
+		return fmt.Errorf("排行榜%s与%s的复合分数编码不同，不能合并", r.key, other.key)
+	}
+	return r.sumInto(dstKey, []string{r.key, other.key})
+}
+
+// sumInto 把srcKeys中各排行榜同一玩家的真实分数相加，写入dst并替换其原有内容，dst可以是来源之一
+// 同分排序值取各来源中最靠前的一个（默认即最早的更新时间）；各来源须与r使用相同的分数位数、方向和同分规则
+// 任一玩家的和超出可表示范围时不做任何修改并返回ErrScoreOutOfRange；
+// 由一个Lua脚本完成，执行期间Redis不处理其他命令，来源很大时应在低峰期调用
+func (r *RedisRankingList) sumInto(dst string, srcKeys []string) error {
+	keys := append([]string{dst}, srcKeys...)
+	res, err := sumComposite.Run(r.ctx, r.client, keys, r.codec.unit(), r.codec.minScore, r.codec.maxScore).Slice()
+	if err != nil {
+		return fmt.Errorf("合并排行榜失败: %w", err)
+	}
+	if ok, _ := res[0].(int64); ok == 0 {
+		member, _ := res[1].(string)
+		sum, _ := res[2].(int64)
+		return fmt.Errorf("合并排行榜失败: 玩家%s: %w", member, r.codec.check(sum))
+	}
+	return nil
+}
+
+// sumComposite 按真实分数求和合并多个排行榜，复合分数直接相加会把低位的同分排序值也加进分数
+// KEYS[1] 目标键  KEYS[2...] 来源键  ARGV[1] 真实分数的单位  ARGV[2] 最低分数  ARGV[3] 最高分数
+// 成功返回{1, 人数}，超出范围返回{0, 玩家ID, 分数和}
+var sumComposite = redis.NewScript(`
+local unit = tonumber(ARGV[1])
+local minScore, maxScore = tonumber(ARGV[2]), tonumber(ARGV[3])
+local sums, lows, members = {}, {}, {}
+for i = 2, #KEYS do
+	local entries = redis.call('ZRANGE', KEYS[i], 0, -1, 'WITHSCORES')
+	for j = 1, #entries, 2 do
+		local member = entries[j]
+		local composite = tonumber(entries[j + 1])
+		local score = math.floor(composite / unit)
+		local low = composite - score * unit
+		if sums[member] == nil then
+			sums[member] = score
+			lows[member] = low
+			members[#members + 1] = member
+		else
+			sums[member] = sums[member] + score
+			if low > lows[member] then
+				lows[member] = low
+			end
+		end
+	end
+end
+for _, member in ipairs(members) do
+	local sum = sums[member]
+	if sum < minScore or sum > maxScore then
+		return {0, member, sum}
+	end
+end
+redis.call('DEL', KEYS[1])
+local args = {}
+for _, member in ipairs(members) do
+	args[#args + 1] = sums[member] * unit + lows[member]
+	args[#args + 1] = member
+	if #args >= 1000 then
+		redis.call('ZADD', KEYS[1], unpack(args))
+		args = {}
+	end
+end
+if #args > 0 then
+	redis.call('ZADD', KEYS[1], unpack(args))
+end
+return {1, #members}
+`)
+
+// RemovePlayer 移除玩家，可重复调用
+// wasPresent表示本次调用前玩家是否在榜，用于区分首次移除和重试
+// 配置了WithTombstone时改为软删除，元数据保留到墓碑被清理；否则同时清理该玩家的墓碑和元数据
+func (r *RedisRankingList) RemovePlayer(playerID string) (wasPresent bool, err error) {
+	defer r.opts.observeUpdate("RemovePlayer", time.Now(), &err)
+	if r.opts.tombstoneGrace > 0 {
+		expireAt := r.opts.now().Add(r.opts.tombstoneGrace).UnixMilli()
+		var moved int
+		err := r.retry(func() (err error) {
+			moved, err = moveToTombstone.Run(r.ctx, r.client, r.tombstoneKeys(), playerID, expireAt).Int()
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("移除玩家失败: %w", err)
+		}
+		return moved > 0, nil
+	}
+
+	var removedCmd *redis.IntCmd
+	err = r.retry(func() error {
+		_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			removedCmd = pipe.ZRem(r.ctx, r.key, playerID)
+			pipe.ZRem(r.ctx, r.tombstoneKey(), playerID)
+			pipe.ZRem(r.ctx, r.tombstoneExpireKey(), playerID)
+			pipe.HDel(r.ctx, r.metaKey(), playerID)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("移除玩家失败: %w", err)
+	}
+	return removedCmd.Val() > 0, nil
+}
+
+// RemovePlayers 批量移除玩家，用于封号和数据删除，removed为本次实际移除的人数，不在榜的ID不计入
+// 硬删除时用一条ZREM移除所有玩家；配置了WithTombstone时通过一次管道逐个软删除
+func (r *RedisRankingList) RemovePlayers(playerIDs []string) (removed int, err error) {
+	defer r.opts.observeUpdate("RemovePlayers", time.Now(), &err)
+	if len(playerIDs) == 0 {
+		return 0, nil
+	}
+
+	if r.opts.tombstoneGrace > 0 {
+		expireAt := r.opts.now().Add(r.opts.tombstoneGrace).UnixMilli()
+		cmds := make([]*redis.Cmd, len(playerIDs))
+		err := r.retry(func() error {
+			_, err := r.client.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+				for i, playerID := range playerIDs {
+					cmds[i] = moveToTombstone.Eval(r.ctx, pipe, r.tombstoneKeys(), playerID, expireAt)
+				}
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			return 0, fmt.Errorf("批量移除玩家失败: %w", err)
+		}
+		for _, cmd := range cmds {
+			n, _ := cmd.Int()
+			removed += n
+		}
+		return removed, nil
+	}
+
+	members := make([]interface{}, len(playerIDs))
+	for i, playerID := range playerIDs {
+		members[i] = playerID
+	}
+	var removedCmd *redis.IntCmd
+	err = r.retry(func() error {
+		_, err := r.client.TxPipelined(r.ctx, func(pipe redis.Pipeliner) error {
+			removedCmd = pipe.ZRem(r.ctx, r.key, members...)
+			pipe.ZRem(r.ctx, r.tombstoneKey(), members...)
+			pipe.ZRem(r.ctx, r.tombstoneExpireKey(), members...)
+			pipe.HDel(r.ctx, r.metaKey(), playerIDs...)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("批量移除玩家失败: %w", err)
+	}
+	return int(removedCmd.Val()), nil
 }
 
-// RemovePlayer 移除玩家
-func (r *RedisRankingList) RemovePlayer(playerID string) error {
-	return r.client.ZRem(r.ctx, r.key, playerID).Err()
+// RestorePlayer 在保留期内把软删除的玩家连同原复合分数移回排行榜，恢复原有名次
+// 玩家删除后重新上榜的，恢复会覆盖其新分数；restored为false表示墓碑不存在或已过期
+func (r *RedisRankingList) RestorePlayer(playerID string) (restored bool, err error) {
+	defer r.opts.observeUpdate("RestorePlayer", time.Now(), &err)
+	n, err := restoreFromTombstone.Run(r.ctx, r.client, r.tombstoneKeys(), playerID, r.opts.now().UnixMilli()).Int()
+	if err != nil {
+		return false, fmt.Errorf("恢复玩家失败: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SweepTombstones 清理已过保留期的墓碑，返回清理的玩家数
+func (r *RedisRankingList) SweepTombstones() (purged int, err error) {
+	defer r.opts.observeUpdate("SweepTombstones", time.Now(), &err)
+	n, err := sweepTombstones.Run(r.ctx, r.client, append(r.tombstoneKeys(), r.metaKey()), r.opts.now().UnixMilli()).Int()
+	if err != nil {
+		return 0, fmt.Errorf("清理墓碑失败: %w", err)
+	}
+	return n, nil
+}
+
+// tombstoneKey 软删除玩家的复合分数
+func (r *RedisRankingList) tombstoneKey() string {
+	return r.key + ":tombstone"
+}
+
+// tombstoneExpireKey 软删除玩家的过期时间（毫秒）
+func (r *RedisRankingList) tombstoneExpireKey() string {
+	return r.key + ":tombstone:expire"
+}
+
+// tombstoneKeys 墓碑脚本使用的键：排行榜、墓碑、墓碑过期时间
+func (r *RedisRankingList) tombstoneKeys() []string {
+	return []string{r.key, r.tombstoneKey(), r.tombstoneExpireKey()}
+}
+
+// moveToTombstone 把玩家移入墓碑
+// KEYS 见tombstoneKeys  ARGV[1] 玩家ID  ARGV[2] 过期时间
+var moveToTombstone = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[2], score, ARGV[1])
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return 1
+`)
+
+// restoreFromTombstone 把未过期的墓碑移回排行榜
+// KEYS 见tombstoneKeys  ARGV[1] 玩家ID  ARGV[2] 当前时间
+var restoreFromTombstone = redis.NewScript(`
+local expireAt = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if not expireAt or tonumber(expireAt) < tonumber(ARGV[2]) then
+	return 0
+end
+local score = redis.call('ZSCORE', KEYS[2], ARGV[1])
+redis.call('ZADD', KEYS[1], score, ARGV[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+return 1
+`)
+
+// sweepTombstones 删除已过期的墓碑及其元数据
+// KEYS 见tombstoneKeys，KEYS[4] 元数据  ARGV[1] 当前时间
+var sweepTombstones = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', '(' .. ARGV[1])
+for _, m in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], m)
+	redis.call('ZREM', KEYS[3], m)
+	redis.call('HDEL', KEYS[4], m)
+end
+return #expired
+`)
+
+// RankMove 玩家在两个排行榜中的名次
+type RankMove struct {
+	PlayerID string
+	RankA    int
+	RankB    int
+}
+
+// CompareBoards 比较两个排行榜的前N名，用于A/B实验对比不同计分公式
+// 按玩家ID匹配，返回只在a中、只在b中的玩家，以及两榜都在但名次不同的玩家，均按各自榜单顺序排列
+func CompareBoards(a, b *RedisRankingList, n int) (onlyA, onlyB []PlayerRank, moved []RankMove, err error) {
+	topA, err := a.GetTopN(n)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	topB, err := b.GetTopN(n)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	inB := make(map[string]PlayerRank, len(topB))
+	for _, pr := range topB {
+		inB[pr.PlayerID] = pr
+	}
+	inA := make(map[string]struct{}, len(topA))
+	for _, pr := range topA {
+		inA[pr.PlayerID] = struct{}{}
+		other, ok := inB[pr.PlayerID]
+		if !ok {
+			onlyA = append(onlyA, pr)
+			continue
+		}
+		if other.Rank != pr.Rank {
+			moved = append(moved, RankMove{PlayerID: pr.PlayerID, RankA: pr.Rank, RankB: other.Rank})
+		}
+	}
+	for _, pr := range topB {
+		if _, ok := inA[pr.PlayerID]; !ok {
+			onlyB = append(onlyB, pr)
+		}
+	}
+
+	return onlyA, onlyB, moved, nil
 }
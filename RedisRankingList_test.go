@@ -0,0 +1,1100 @@
+package game_rank_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// topIDs 前N名的玩家ID
+func topIDs(t *testing.T, r Ranker, n int) []string {
+	t.Helper()
+	top, err := r.GetTopN(n)
+	if err != nil {
+		t.Fatalf("GetTopN: %v", err)
+	}
+	ids := make([]string, len(top))
+	for i, pr := range top {
+		ids[i] = pr.PlayerID
+	}
+	return ids
+}
+
+// TestCollisionAvoidanceOrder 同分同一秒写入时按同分排序方向决定先后，而不是按成员字典序
+// EarliestFirst时先占用复合分数的玩家排在前面，LatestFirst时后写入的玩家排在前面
+func TestCollisionAvoidanceOrder(t *testing.T) {
+	cases := []struct {
+		tieBreak TieBreakOrder
+		want     []string
+	}{
+		{EarliestFirst, []string{"a", "b", "c"}},
+		{LatestFirst, []string{"c", "b", "a"}},
+	}
+	for _, tc := range cases {
+		clock := newTestClock()
+		r, _ := newTestRedis(t, WithClock(clock.now), WithTieBreak(tc.tieBreak), WithCollisionAvoidance())
+
+		// a、c在字典序上位于两端，不避让时两种方向都会按成员字典序降序排列
+		for _, id := range []string{"a", "b", "c"} {
+			if err := r.UpdateScore(id, 10); err != nil {
+				t.Fatalf("UpdateScore(%s): %v", id, err)
+			}
+		}
+		if got := topIDs(t, r, 3); !equalIDs(got, tc.want) {
+			t.Errorf("tieBreak=%v order = %v, want %v", tc.tieBreak, got, tc.want)
+		}
+		for _, id := range []string{"a", "b", "c"} {
+			if score, err := r.GetScore(id); err != nil || score != 10 {
+				t.Errorf("tieBreak=%v GetScore(%s) = %d, %v, want 10", tc.tieBreak, id, score, err)
+			}
+		}
+	}
+}
+
+// TestCollisionAvoidanceBucketFloor 低位已到同一真实分数的边界时不能再移动，返回ErrTieBreakExhausted且不改变真实分数
+func TestCollisionAvoidanceBucketFloor(t *testing.T) {
+	// 超出同分排序时间上限后EarliestFirst的低位恒为0，LatestFirst的低位恒为上限
+	for _, tieBreak := range []TieBreakOrder{EarliestFirst, LatestFirst} {
+		clock := &testClock{t: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)}
+		r, _ := newTestRedis(t, WithClock(clock.now), WithTieBreak(tieBreak), WithCollisionAvoidance())
+
+		if err := r.UpdateScore("a", 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpdateScore("b", 10); !errors.Is(err, ErrTieBreakExhausted) {
+			t.Fatalf("tieBreak=%v UpdateScore(b) error = %v, want ErrTieBreakExhausted", tieBreak, err)
+		}
+		if ok, err := r.Exists("b"); err != nil || ok {
+			t.Errorf("tieBreak=%v Exists(b) = %v, %v, want false", tieBreak, ok, err)
+		}
+		if err := r.UpdateScores(map[string]int64{"c": 10}); !errors.Is(err, ErrTieBreakExhausted) {
+			t.Errorf("tieBreak=%v UpdateScores error = %v, want ErrTieBreakExhausted", tieBreak, err)
+		}
+		if score, err := r.GetScore("a"); err != nil || score != 10 {
+			t.Errorf("tieBreak=%v GetScore(a) = %d, %v, want 10", tieBreak, score, err)
+		}
+		if top := topIDs(t, r, 10); len(top) != 1 {
+			t.Errorf("tieBreak=%v board = %v, want only a", tieBreak, top)
+		}
+	}
+}
+
+// TestEnsurePlayerTrims WithMaxSize的榜单通过EnsurePlayer加人时同样裁剪，人数不超过上限
+func TestEnsurePlayerTrims(t *testing.T) {
+	clock := newTestClock()
+	r, _ := newTestRedis(t, WithClock(clock.now), WithMaxSize(3))
+	for _, id := range []string{"a", "b", "c"} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(id, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"d", "e", "f"} {
+		if _, err := r.EnsurePlayer(id); err != nil {
+			t.Fatalf("EnsurePlayer(%s): %v", id, err)
+		}
+	}
+	if total, err := r.GetTotalPlayers(); err != nil || total != 3 {
+		t.Fatalf("GetTotalPlayers() = %d, %v, want 3", total, err)
+	}
+	if got := topIDs(t, r, 3); got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("board = %v, want the three scored players", got)
+	}
+}
+
+// TestTrimOnWrite 超出容量写入后榜单保持在上限内，WithTrimEvery(k)时最多超出k-1人
+func TestTrimOnWrite(t *testing.T) {
+	for _, every := range []int{1, 3} {
+		r, _ := newTestRedis(t, WithMaxSize(5), WithTrimEvery(every))
+		for i := 0; i < 20; i++ {
+			if err := r.UpdateScore(fmt.Sprintf("p%02d", i), int64(i)); err != nil {
+				t.Fatal(err)
+			}
+			if total, err := r.GetTotalPlayers(); err != nil || total > int64(5+every-1) {
+				t.Fatalf("every=%d after %d writes: GetTotalPlayers() = %d, %v", every, i+1, total, err)
+			}
+		}
+		top, err := r.GetTopN(1)
+		if err != nil || top[0].PlayerID != "p19" {
+			t.Errorf("every=%d: top = %v, %v, want p19", every, top, err)
+		}
+	}
+}
+
+// TestCollisionAvoidanceSecondaryTieBreak 低位存放次要排序值时所有写入路径都不避让，次要排序值原样写入
+func TestCollisionAvoidanceSecondaryTieBreak(t *testing.T) {
+	r, mr := newTestRedis(t, WithSecondaryTieBreak(), WithCollisionAvoidance(), WithMaxSize(10))
+	if err := r.UpdateScores(map[string]int64{"a": 10, "b": 10, "c": 10}); err != nil {
+		t.Fatal(err)
+	}
+	r.OnRankChange(func(string, int, int) {})
+	if err := r.UpdateScore("d", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	tieBreak, _ := r.codec.secondaryOf(0)
+	want := r.codec.encode(10, tieBreak)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if composite, err := mr.ZScore("rank:test", id); err != nil || composite != want {
+			t.Errorf("composite of %s = %v, %v, want %v", id, composite, err, want)
+		}
+	}
+}
+
+// rankChange 一次名次变化回调
+type rankChange struct {
+	playerID         string
+	oldRank, newRank int
+}
+
+// recordRankChanges 注册回调并返回记录到的名次变化
+func recordRankChanges(r interface{ OnRankChange(RankChangeFunc) }) *[]rankChange {
+	changes := &[]rankChange{}
+	r.OnRankChange(func(playerID string, oldRank, newRank int) {
+		*changes = append(*changes, rankChange{playerID, oldRank, newRank})
+	})
+	return changes
+}
+
+// TestRankChangeTrimmed 被更新的玩家降分后被WithMaxSize裁剪时回调newRank为0，新玩家随即被裁剪时不回调
+func TestRankChangeTrimmed(t *testing.T) {
+	clock := newTestClock()
+	// 每2次写入裁剪一次，未裁剪的写入之间榜单可以暂时超出上限
+	r, _ := newTestRedis(t, WithClock(clock.now), WithMaxSize(2), WithTrimEvery(2))
+	if err := r.UpdateScores(map[string]int64{"a": 30, "b": 20}); err != nil {
+		t.Fatal(err)
+	}
+	changes := recordRankChanges(r)
+
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"c", 25}, {"a", 5}, {"d", 1}, {"e", 0}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []rankChange{{"c", 0, 2}, {"a", 1, 0}, {"d", 0, 3}}
+	if !reflect.DeepEqual(*changes, want) {
+		t.Errorf("rank changes = %v, want %v", *changes, want)
+	}
+	if got := topIDs(t, r, 10); !equalIDs(got, []string{"c", "b"}) {
+		t.Errorf("board = %v, want [c b]", got)
+	}
+}
+
+// TestETagChangesWithMetadata 前N名结果包含元数据，修改元数据后ETag随之变化
+func TestETagChangesWithMetadata(t *testing.T) {
+	type etagRanker interface {
+		Ranker
+		SetMetadata(playerID string, meta PlayerMeta) error
+		GetTopNWithETag(n int) ([]PlayerRank, string, error)
+	}
+	rds, _ := newTestRedis(t, WithMetadata())
+	for _, r := range []etagRanker{NewRankingSystem(), rds} {
+		if err := r.UpdateScore("a", 10); err != nil {
+			t.Fatal(err)
+		}
+		_, before, err := r.GetTopNWithETag(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetMetadata("a", PlayerMeta{"name": "Alice"}); err != nil {
+			t.Fatal(err)
+		}
+		top, after, err := r.GetTopNWithETag(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after == before {
+			t.Errorf("%T ETag unchanged after SetMetadata", r)
+		}
+		if top[0].Meta["name"] != "Alice" {
+			t.Errorf("%T Meta = %v", r, top[0].Meta)
+		}
+	}
+}
+
+// explainsOrder 按TieBreakEntry的比较规则，a是否应排在b之前
+func explainsOrder(a, b TieBreakEntry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Tiebreak != b.Tiebreak {
+		return a.Tiebreak > b.Tiebreak
+	}
+	if !a.TieBreak.Equal(b.TieBreak) {
+		return a.TieBreak.Before(b.TieBreak)
+	}
+	return a.PlayerID > b.PlayerID
+}
+
+// TestTieBreakEntryExplainsOrder GetTopNTieBreak返回的比较值能解释同分玩家的先后，包括同一秒内的同分和次要排序值
+func TestTieBreakEntryExplainsOrder(t *testing.T) {
+	type tieBreakRanker interface {
+		Ranker
+		UpdateScoreWithTieBreak(playerID string, score, tiebreak int64) error
+		GetTopNTieBreak(n int) ([]TieBreakEntry, error)
+	}
+	check := func(r tieBreakRanker, want []string) {
+		t.Helper()
+		entries, err := r.GetTopNTieBreak(len(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(entries))
+		for _, e := range entries {
+			got = append(got, e.PlayerID)
+		}
+		if !equalIDs(got, want) {
+			t.Fatalf("%T order = %v, want %v", r, got, want)
+		}
+		for i := 1; i < len(entries); i++ {
+			if !explainsOrder(entries[i-1], entries[i]) {
+				t.Errorf("%T entries %+v and %+v do not explain their order", r, entries[i-1], entries[i])
+			}
+		}
+	}
+
+	// a比b早300毫秒但同一秒上榜，比较时间相同，按ID降序b在前；c晚一秒排在最后
+	memClock, rdsClock := newTestClock(), newTestClock()
+	rds, _ := newTestRedis(t, WithClock(rdsClock.now))
+	for _, board := range []struct {
+		clock *testClock
+		r     tieBreakRanker
+	}{{memClock, NewRankingSystem(WithClock(memClock.now))}, {rdsClock, rds}} {
+		clock, r := board.clock, board.r
+		for _, step := range []struct {
+			id      string
+			advance time.Duration
+		}{{"a", 200 * time.Millisecond}, {"b", 300 * time.Millisecond}, {"c", time.Second}} {
+			clock.advance(step.advance)
+			if err := r.UpdateScore(step.id, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(r, []string{"b", "a", "c"})
+	}
+
+	// 次要排序值大的排在前面，与ID和更新时间无关
+	clock := newTestClock()
+	rds, _ = newTestRedis(t, WithClock(clock.now), WithSecondaryTieBreak())
+	for _, r := range []tieBreakRanker{NewRankingSystem(WithClock(clock.now)), rds} {
+		for _, s := range []struct {
+			id       string
+			tiebreak int64
+		}{{"x", 1}, {"y", 5}, {"z", -3}} {
+			clock.advance(time.Second)
+			if err := r.UpdateScoreWithTieBreak(s.id, 10, s.tiebreak); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(r, []string{"y", "x", "z"})
+	}
+}
+
+// TestScoreRankForListDuplicates 结果与输入逐位对应，重复ID得到两行相同结果，不在榜的玩家名次为0
+func TestScoreRankForListDuplicates(t *testing.T) {
+	type listRanker interface {
+		Ranker
+		GetScoreRankForList(playerIDs []string) ([]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []listRanker{NewRankingSystem(), rds} {
+		for id, score := range map[string]int64{"a": 30, "b": 20, "c": 10} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := r.GetScoreRankForList([]string{"c", "a", "ghost", "c"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []PlayerRank{
+			{PlayerID: "c", Score: 10, Rank: 3},
+			{PlayerID: "a", Score: 30, Rank: 1},
+			{PlayerID: "ghost"},
+			{PlayerID: "c", Score: 10, Rank: 3},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%T GetScoreRankForList = %+v", r, got)
+		}
+		for i, w := range want {
+			if got[i].PlayerID != w.PlayerID || got[i].Score != w.Score || got[i].Rank != w.Rank {
+				t.Errorf("%T row %d = %+v, want %+v", r, i, got[i], w)
+			}
+		}
+	}
+}
+
+// TestScoreQuantizerTies k=10时103和107都存为100，两名玩家同分并列，先到的排在前面
+func TestScoreQuantizerTies(t *testing.T) {
+	clock := newTestClock()
+	rds, _ := newTestRedis(t, WithClock(clock.now), WithScoreQuantizer(QuantizeFloor(10)))
+	for _, r := range []Ranker{NewRankingSystem(WithClock(clock.now), WithScoreQuantizer(QuantizeFloor(10))), rds} {
+		for _, s := range []struct {
+			id    string
+			score int64
+		}{{"a", 103}, {"b", 107}} {
+			clock.advance(time.Second)
+			if err := r.UpdateScore(s.id, s.score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range []string{"a", "b"} {
+			rank, score, err := r.GetRank(id)
+			if err != nil || rank != 1 || score != 100 {
+				t.Errorf("%T GetRank(%s) = %d, %d, %v, want 1, 100", r, id, rank, score, err)
+			}
+		}
+		if got := topIDs(t, r, 2); !equalIDs(got, []string{"a", "b"}) {
+			t.Errorf("%T top = %v, want [a b]", r, got)
+		}
+	}
+}
+
+// TestIsEmpty 空榜返回true，有玩家后返回false，移除最后一名玩家后再次为空
+func TestIsEmpty(t *testing.T) {
+	type emptyRanker interface {
+		Ranker
+		IsEmpty() (bool, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []emptyRanker{NewRankingSystem(), rds} {
+		expect := func(want bool) {
+			t.Helper()
+			if empty, err := r.IsEmpty(); err != nil || empty != want {
+				t.Errorf("%T IsEmpty() = %v, %v, want %v", r, empty, err, want)
+			}
+		}
+		expect(true)
+		if err := r.UpdateScore("a", 10); err != nil {
+			t.Fatal(err)
+		}
+		expect(false)
+		if _, err := r.RemovePlayer("a"); err != nil {
+			t.Fatal(err)
+		}
+		expect(true)
+	}
+}
+
+// TestRankPageInsideTie 分页从并列中间开始时，页首沿用并列名次，标准和密集排名都正确
+func TestRankPageInsideTie(t *testing.T) {
+	for _, tc := range []struct {
+		mode RankMode
+		want []int
+	}{
+		{StandardRanking, []int{2, 2, 5}},
+		{DenseRanking, []int{2, 2, 3}},
+	} {
+		rds, _ := newTestRedis(t, WithRankMode(tc.mode))
+		for _, r := range []Ranker{NewRankingSystem(WithRankMode(tc.mode)), rds} {
+			for id, score := range map[string]int64{"a": 50, "b": 40, "c": 40, "d": 40, "e": 30} {
+				if err := r.UpdateScore(id, score); err != nil {
+					t.Fatal(err)
+				}
+			}
+			page, err := r.GetRankPage(2, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) != len(tc.want) {
+				t.Fatalf("%T mode=%v GetRankPage(2, 3) = %+v", r, tc.mode, page)
+			}
+			for i, rank := range tc.want {
+				if page[i].Rank != rank {
+					t.Errorf("%T mode=%v page[%d] = %+v, want rank %d", r, tc.mode, i, page[i], rank)
+				}
+			}
+		}
+	}
+}
+
+// TestRankBandScores 30名玩家分数为300到10，第10到20名的首尾分数为210和110，区间超出人数时按最后一名计算
+func TestRankBandScores(t *testing.T) {
+	type bandRanker interface {
+		Ranker
+		RankBandScores(startRank, endRank int) (int64, int64, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []bandRanker{NewRankingSystem(), rds} {
+		for i := 1; i <= 30; i++ {
+			if err := r.UpdateScore(fmt.Sprintf("p%02d", i), int64(310-10*i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range []struct {
+			start, end int
+			high, low  int64
+		}{{10, 20, 210, 110}, {1, 10, 300, 210}, {25, 40, 60, 10}} {
+			high, low, err := r.RankBandScores(tc.start, tc.end)
+			if err != nil || high != tc.high || low != tc.low {
+				t.Errorf("%T RankBandScores(%d, %d) = %d, %d, %v, want %d, %d", r, tc.start, tc.end, high, low, err, tc.high, tc.low)
+			}
+		}
+	}
+}
+
+// TestRemovePlayerTwice 重复移除同一玩家，第一次wasPresent为true，第二次为false且不报错；Redis的元数据一并清理
+func TestRemovePlayerTwice(t *testing.T) {
+	type metaRanker interface {
+		Ranker
+		SetMetadata(playerID string, meta PlayerMeta) error
+	}
+	rds, mr := newTestRedis(t, WithMetadata())
+	for _, r := range []metaRanker{NewRankingSystem(), rds} {
+		if err := r.UpdateScore("a", 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetMetadata("a", PlayerMeta{"name": "Alice"}); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []bool{true, false} {
+			if wasPresent, err := r.RemovePlayer("a"); err != nil || wasPresent != want {
+				t.Errorf("%T RemovePlayer #%d = %v, %v, want %v", r, i+1, wasPresent, err, want)
+			}
+		}
+		if exists, err := r.Exists("a"); err != nil || exists {
+			t.Errorf("%T Exists after removal = %v, %v", r, exists, err)
+		}
+	}
+	if mr.Exists(rds.metaKey()) {
+		t.Errorf("metadata key %s still exists after removal", rds.metaKey())
+	}
+}
+
+// TestEnsurePlayerIdempotent 新玩家以0分加入排在最后，重复调用不再创建，已有分数不被覆盖
+func TestEnsurePlayerIdempotent(t *testing.T) {
+	type ensureRanker interface {
+		Ranker
+		EnsurePlayer(playerID string) (bool, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []ensureRanker{NewRankingSystem(), rds} {
+		if err := r.UpdateScore("a", 50); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range []bool{true, false} {
+			if created, err := r.EnsurePlayer("new"); err != nil || created != want {
+				t.Errorf("%T EnsurePlayer(new) #%d = %v, %v, want %v", r, i+1, created, err, want)
+			}
+		}
+		if created, err := r.EnsurePlayer("a"); err != nil || created {
+			t.Errorf("%T EnsurePlayer(a) = %v, %v, want false", r, created, err)
+		}
+		if score, err := r.GetScore("a"); err != nil || score != 50 {
+			t.Errorf("%T GetScore(a) = %d, %v, want 50", r, score, err)
+		}
+		if rank, score, err := r.GetRank("new"); err != nil || rank != 2 || score != 0 {
+			t.Errorf("%T GetRank(new) = %d, %d, %v, want 2, 0", r, rank, score, err)
+		}
+		if total, err := r.GetTotalPlayers(); err != nil || total != 2 {
+			t.Errorf("%T GetTotalPlayers = %d, %v, want 2", r, total, err)
+		}
+	}
+}
+
+// TestRankRangeByPercentile 100名玩家的榜单上前5%为第1到5名，5%到20%为第6到20名，名次为全榜名次
+func TestRankRangeByPercentile(t *testing.T) {
+	type percentileRanker interface {
+		Ranker
+		RankRangeByPercentile(lowPct, highPct float64) ([]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []percentileRanker{NewRankingSystem(), rds} {
+		for i := 1; i <= 100; i++ {
+			if err := r.UpdateScore(fmt.Sprintf("p%03d", i), int64(1000-i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range []struct {
+			low, high        float64
+			firstRank, count int
+		}{{0, 0.05, 1, 5}, {0.05, 0.2, 6, 15}} {
+			band, err := r.RankRangeByPercentile(tc.low, tc.high)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(band) != tc.count {
+				t.Fatalf("%T RankRangeByPercentile(%v, %v) returned %d players, want %d", r, tc.low, tc.high, len(band), tc.count)
+			}
+			for i, pr := range band {
+				rank := tc.firstRank + i
+				if pr.Rank != rank || pr.PlayerID != fmt.Sprintf("p%03d", rank) {
+					t.Errorf("%T RankRangeByPercentile(%v, %v)[%d] = %+v, want p%03d at rank %d", r, tc.low, tc.high, i, pr, rank, rank)
+				}
+			}
+		}
+	}
+}
+
+// TestCompareBoards 两榜前4名中d只在a榜、e只在b榜，a和b互换名次，c名次相同不报告
+func TestCompareBoards(t *testing.T) {
+	a, _ := newTestRedis(t)
+	b, _ := newTestRedis(t)
+	for board, scores := range map[*RedisRankingList]map[string]int64{
+		a: {"a": 50, "b": 40, "c": 30, "d": 20, "x": 1},
+		b: {"b": 50, "a": 40, "c": 30, "e": 20, "d": 1},
+	} {
+		for id, score := range scores {
+			if err := board.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	onlyA, onlyB, moved, err := CompareBoards(a, b, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onlyA) != 1 || onlyA[0].PlayerID != "d" || onlyA[0].Rank != 4 {
+		t.Errorf("onlyA = %+v, want d at rank 4", onlyA)
+	}
+	if len(onlyB) != 1 || onlyB[0].PlayerID != "e" || onlyB[0].Rank != 4 {
+		t.Errorf("onlyB = %+v, want e at rank 4", onlyB)
+	}
+	want := []RankMove{{PlayerID: "a", RankA: 1, RankB: 2}, {PlayerID: "b", RankA: 2, RankB: 1}}
+	if !reflect.DeepEqual(moved, want) {
+		t.Errorf("moved = %+v, want %+v", moved, want)
+	}
+}
+
+// TestPlayerRankRangeDefaultWindow n<=0时使用WithDefaultWindow配置的窗口并以玩家为中心，Strict版本仍返回ErrInvalidN
+func TestPlayerRankRangeDefaultWindow(t *testing.T) {
+	type windowRanker interface {
+		Ranker
+		GetPlayerRankRangeStrict(playerID string, n int) ([]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t, WithDefaultWindow(3))
+	for _, r := range []windowRanker{NewRankingSystem(WithDefaultWindow(3)), rds} {
+		for i := 1; i <= 10; i++ {
+			if err := r.UpdateScore(fmt.Sprintf("p%02d", i), int64(100-i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, n := range []int{0, -1} {
+			window, err := r.GetPlayerRankRange("p05", n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ranks []int
+			for _, pr := range window {
+				ranks = append(ranks, pr.Rank)
+			}
+			if !reflect.DeepEqual(ranks, []int{4, 5, 6}) {
+				t.Errorf("%T GetPlayerRankRange(p05, %d) ranks = %v, want [4 5 6]", r, n, ranks)
+			}
+		}
+		if _, err := r.GetPlayerRankRangeStrict("p05", 0); !errors.Is(err, ErrInvalidN) {
+			t.Errorf("%T GetPlayerRankRangeStrict(p05, 0) error = %v, want ErrInvalidN", r, err)
+		}
+	}
+}
+
+// TestRankChangeSinceBaseline 保存基线后更新分数，各玩家的名次变化正确，基线之后上榜的玩家按基线人数+1名计算
+func TestRankChangeSinceBaseline(t *testing.T) {
+	type baselineRanker interface {
+		Ranker
+		CaptureBaseline(name string) error
+		RankChangeSinceBaseline(name, playerID string) (int, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []baselineRanker{NewRankingSystem(), rds} {
+		for id, score := range map[string]int64{"a": 30, "b": 20, "c": 10} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.CaptureBaseline("season"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 新名次：c第1，a第2，d第3，b第4
+		for id, score := range map[string]int64{"c": 100, "d": 25} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for id, want := range map[string]int{"a": -1, "b": -2, "c": 2, "d": 1} {
+			if delta, err := r.RankChangeSinceBaseline("season", id); err != nil || delta != want {
+				t.Errorf("%T RankChangeSinceBaseline(season, %s) = %d, %v, want %d", r, id, delta, err, want)
+			}
+		}
+		if _, err := r.RankChangeSinceBaseline("missing", "a"); !errors.Is(err, ErrBaselineNotFound) {
+			t.Errorf("%T RankChangeSinceBaseline(missing) error = %v, want ErrBaselineNotFound", r, err)
+		}
+	}
+}
+
+// TestEncodeTopNJSON 跨越多个编码批次的流式输出是合法JSON，解码后与GetTopN一致；n超过总人数时输出全榜
+func TestEncodeTopNJSON(t *testing.T) {
+	type encodeRanker interface {
+		Ranker
+		UpdateScores(updates map[string]int64) error
+		EncodeTopNJSON(w io.Writer, n int) error
+	}
+	updates := make(map[string]int64, 2500)
+	for i := 0; i < 2500; i++ {
+		updates[fmt.Sprintf("p%04d", i)] = int64(i % 700)
+	}
+	clock := newTestClock()
+	rds, _ := newTestRedis(t, WithClock(clock.now))
+	for _, r := range []encodeRanker{NewRankingSystem(WithClock(clock.now)), rds} {
+		if err := r.UpdateScores(updates); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{2300, 3000} {
+			var buf bytes.Buffer
+			if err := r.EncodeTopNJSON(&buf, n); err != nil {
+				t.Fatal(err)
+			}
+			var decoded []PlayerRank
+			if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+				t.Fatalf("%T EncodeTopNJSON(%d) produced invalid JSON: %v", r, n, err)
+			}
+			top, err := r.GetTopN(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(decoded) != len(top) {
+				t.Fatalf("%T EncodeTopNJSON(%d) has %d entries, GetTopN has %d", r, n, len(decoded), len(top))
+			}
+			for i := range top {
+				got, want := decoded[i], top[i]
+				if got.PlayerID != want.PlayerID || got.Score != want.Score || got.Rank != want.Rank || !got.UpdateTime.Equal(want.UpdateTime) {
+					t.Fatalf("%T EncodeTopNJSON(%d)[%d] = %+v, want %+v", r, n, i, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestGetTopNMap map中恰好是前N名玩家，名次与GetTopN一致，第N名之后的玩家不在map中
+func TestGetTopNMap(t *testing.T) {
+	type mapRanker interface {
+		Ranker
+		GetTopNMap(n int) (map[string]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []mapRanker{NewRankingSystem(), rds} {
+		for id, score := range map[string]int64{"a": 50, "b": 40, "c": 40, "d": 30, "e": 20} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		top, err := r.GetTopNMap(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]int{"a": 1, "b": 2, "c": 2, "d": 4}
+		if len(top) != len(want) {
+			t.Errorf("%T GetTopNMap(4) = %+v", r, top)
+		}
+		for id, rank := range want {
+			if pr, ok := top[id]; !ok || pr.Rank != rank || pr.PlayerID != id {
+				t.Errorf("%T GetTopNMap(4)[%s] = %+v, %v, want rank %d", r, id, pr, ok, rank)
+			}
+		}
+		if _, ok := top["e"]; ok {
+			t.Errorf("%T GetTopNMap(4) contains e", r)
+		}
+	}
+}
+
+// TestForEachResume 导出遍历到一半中断，从返回的游标继续后覆盖全部玩家，分数正确
+func TestForEachResume(t *testing.T) {
+	r, _ := newTestRedis(t)
+	updates := make(map[string]int64, 500)
+	for i := 0; i < 500; i++ {
+		updates[fmt.Sprintf("p%03d", i)] = int64(i)
+	}
+	if err := r.UpdateScores(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int64, len(updates))
+	collect := func(pr PlayerRank) {
+		if score, ok := seen[pr.PlayerID]; ok && score != pr.Score {
+			t.Errorf("%s seen with scores %d and %d", pr.PlayerID, score, pr.Score)
+		}
+		seen[pr.PlayerID] = pr.Score
+	}
+
+	// 前半程看到250名玩家后中断，模拟导出进程崩溃
+	visited := 0
+	cursor, done, err := r.ForEach(0, 100, func(pr PlayerRank) bool {
+		collect(pr)
+		visited++
+		return visited < 250
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done {
+		t.Fatal("first half reported done")
+	}
+
+	for !done {
+		cursor, done, err = r.ForEach(cursor, 100, func(pr PlayerRank) bool {
+			collect(pr)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(seen) != len(updates) {
+		t.Fatalf("resumed export covered %d players, want %d", len(seen), len(updates))
+	}
+	for id, score := range updates {
+		if seen[id] != score {
+			t.Errorf("%s exported score %d, want %d", id, seen[id], score)
+		}
+	}
+}
+
+// TestCutoffScore 第n名的分数即进入前n名的最低分数，人数不足n时返回ErrNotEnoughPlayers
+func TestCutoffScore(t *testing.T) {
+	type cutoffRanker interface {
+		Ranker
+		CutoffScore(n int) (int64, error)
+	}
+	rds, _ := newTestRedis(t)
+	for _, r := range []cutoffRanker{NewRankingSystem(), rds} {
+		for id, score := range map[string]int64{"a": 4500, "b": 4200, "c": 4200, "d": 3000} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		top, err := r.GetTopN(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 1; n <= 4; n++ {
+			if cutoff, err := r.CutoffScore(n); err != nil || cutoff != top[n-1].Score {
+				t.Errorf("%T CutoffScore(%d) = %d, %v, want %d", r, n, cutoff, err, top[n-1].Score)
+			}
+		}
+		if _, err := r.CutoffScore(5); !errors.Is(err, ErrNotEnoughPlayers) {
+			t.Errorf("%T CutoffScore(5) error = %v, want ErrNotEnoughPlayers", r, err)
+		}
+	}
+}
+
+// TestTopNInTimeWindow 5000名一小时前更新的玩家分数都更高，时间窗口内只有4名最近更新的玩家参与排名
+func TestTopNInTimeWindow(t *testing.T) {
+	clock := newTestClock()
+	r, _ := newTestRedis(t, WithClock(clock.now))
+	updates := make(map[string]int64, 5000)
+	for i := 0; i < 5000; i++ {
+		updates[fmt.Sprintf("old%04d", i)] = int64(1000 + i)
+	}
+	if err := r.UpdateScores(updates); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.advance(time.Hour)
+	since := clock.now()
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"r5", 5}, {"r50a", 50}, {"r50b", 50}, {"r7", 7}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		n    int
+		want []PlayerRank
+	}{
+		{3, []PlayerRank{{PlayerID: "r50a", Score: 50, Rank: 1}, {PlayerID: "r50b", Score: 50, Rank: 1}, {PlayerID: "r7", Score: 7, Rank: 3}}},
+		{10, []PlayerRank{{PlayerID: "r50a", Score: 50, Rank: 1}, {PlayerID: "r50b", Score: 50, Rank: 1}, {PlayerID: "r7", Score: 7, Rank: 3}, {PlayerID: "r5", Score: 5, Rank: 4}}},
+	} {
+		top, err := r.GetTopNInTimeWindow(tc.n, since, clock.now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(top, tc.want) {
+			t.Errorf("GetTopNInTimeWindow(%d) = %+v, want %+v", tc.n, top, tc.want)
+		}
+	}
+}
+
+// TestCountInScoreRange 区间两端都包含，同分的多名玩家和边界上的玩家都计入
+func TestCountInScoreRange(t *testing.T) {
+	type countRanker interface {
+		Ranker
+		CountInScoreRange(minScore, maxScore int64) (int64, error)
+	}
+	clock := newTestClock()
+	rds, _ := newTestRedis(t, WithClock(clock.now))
+	for _, r := range []countRanker{NewRankingSystem(WithClock(clock.now)), rds} {
+		for id, score := range map[string]int64{"a": 9, "b": 10, "c": 10, "d": 15, "e": 20, "f": 21} {
+			clock.advance(time.Second)
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range []struct {
+			min, max int64
+			want     int64
+		}{{10, 20, 4}, {10, 10, 2}, {11, 14, 0}, {20, 21, 2}, {21, 100, 1}, {-5, 9, 1}} {
+			if count, err := r.CountInScoreRange(tc.min, tc.max); err != nil || count != tc.want {
+				t.Errorf("%T CountInScoreRange(%d, %d) = %d, %v, want %d", r, tc.min, tc.max, count, err, tc.want)
+			}
+		}
+	}
+}
+
+// TestPlayerRankRangeEdges 榜首和榜尾的窗口整体平移仍返回n名，n为偶数时后面多一名，n超过总人数时返回全榜
+func TestPlayerRankRangeEdges(t *testing.T) {
+	rds, _ := newTestRedis(t)
+	for _, r := range []Ranker{NewRankingSystem(), rds} {
+		for i := 1; i <= 10; i++ {
+			if err := r.UpdateScore(fmt.Sprintf("p%02d", i), int64(100-i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, tc := range []struct {
+			player string
+			n      int
+			want   []int
+		}{
+			{"p01", 5, []int{1, 2, 3, 4, 5}},
+			{"p10", 5, []int{6, 7, 8, 9, 10}},
+			{"p05", 4, []int{4, 5, 6, 7}},
+			{"p05", 20, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		} {
+			window, err := r.GetPlayerRankRange(tc.player, tc.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ranks := make([]int, 0, len(window))
+			for i, pr := range window {
+				ranks = append(ranks, pr.Rank)
+				if pr.Position != i+1 {
+					t.Errorf("%T GetPlayerRankRange(%s, %d)[%d].Position = %d", r, tc.player, tc.n, i, pr.Position)
+				}
+			}
+			if !reflect.DeepEqual(ranks, tc.want) {
+				t.Errorf("%T GetPlayerRankRange(%s, %d) ranks = %v, want %v", r, tc.player, tc.n, ranks, tc.want)
+			}
+		}
+	}
+}
+
+// TestNormalizeStrictOrder 同分排序时间用尽后同分玩家的复合分数完全相同，外部写入的复合分数还带有小数误差；
+// Normalize后复合分数都是整数且严格递减，排名顺序和真实分数不变
+func TestNormalizeStrictOrder(t *testing.T) {
+	clock := newTestClock()
+	r, mr := newTestRedis(t, WithClock(clock.now))
+	clock.advance(r.codec.tieBreakTime(r.codec.tieBreakMax).Sub(clock.now()) + 24*time.Hour)
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"a", 100}, {"b", 100}, {"c", 100}, {"d", 50}, {"e", 200}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drifted := r.codec.encode(100, r.codec.tieBreakOf(clock.now())) + 0.25
+	if _, err := mr.ZAdd(r.key, drifted, "f"); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() []redis.Z {
+		t.Helper()
+		zs, err := r.client.ZRevRangeWithScores(r.ctx, r.key, 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zs
+	}
+	before := read()
+	ambiguous := false
+	for i := 1; i < len(before); i++ {
+		if r.keyScore(before[i].Score) == r.keyScore(before[i-1].Score) && int64(before[i].Score) == int64(before[i-1].Score) {
+			ambiguous = true
+		}
+	}
+	if !ambiguous {
+		t.Fatalf("board %v has no precision-ambiguous ties", before)
+	}
+
+	if err := r.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	after := read()
+	if len(after) != len(before) {
+		t.Fatalf("Normalize changed the board size from %d to %d", len(before), len(after))
+	}
+	for i, z := range after {
+		if z.Member != before[i].Member {
+			t.Errorf("position %d = %v after Normalize, want %v", i, z.Member, before[i].Member)
+		}
+		if r.keyScore(z.Score) != r.keyScore(before[i].Score) {
+			t.Errorf("%v score = %d after Normalize, want %d", z.Member, r.keyScore(z.Score), r.keyScore(before[i].Score))
+		}
+		if z.Score != float64(int64(z.Score)) {
+			t.Errorf("%v composite %v is not an integer", z.Member, z.Score)
+		}
+		if i > 0 && z.Score >= after[i-1].Score {
+			t.Errorf("%v composite %v not below %v composite %v", z.Member, z.Score, after[i-1].Member, after[i-1].Score)
+		}
+	}
+}
+
+// TestRestorePlayerWithinGrace 软删除后在保留期内恢复，玩家回到原名次，同分时仍排在原来的位置
+func TestRestorePlayerWithinGrace(t *testing.T) {
+	clock := newTestClock()
+	r, _ := newTestRedis(t, WithClock(clock.now), WithTombstone(time.Hour))
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"a", 30}, {"b", 20}, {"c", 20}, {"d", 10}} {
+		clock.advance(time.Second)
+		if err := r.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if wasPresent, err := r.RemovePlayer("b"); err != nil || !wasPresent {
+		t.Fatalf("RemovePlayer(b) = %v, %v", wasPresent, err)
+	}
+	if got := topIDs(t, r, 10); !equalIDs(got, []string{"a", "c", "d"}) {
+		t.Fatalf("board after removal = %v, want [a c d]", got)
+	}
+
+	clock.advance(30 * time.Minute)
+	if restored, err := r.RestorePlayer("b"); err != nil || !restored {
+		t.Fatalf("RestorePlayer(b) = %v, %v, want true", restored, err)
+	}
+	if rank, score, err := r.GetRank("b"); err != nil || rank != 2 || score != 20 {
+		t.Errorf("GetRank(b) = %d, %d, %v, want 2, 20", rank, score, err)
+	}
+	if got := topIDs(t, r, 10); !equalIDs(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("board after restore = %v, want [a b c d]", got)
+	}
+	if restored, err := r.RestorePlayer("b"); err != nil || restored {
+		t.Errorf("second RestorePlayer(b) = %v, %v, want false", restored, err)
+	}
+}
+
+// TestSweepTombstones 过期的墓碑不能再恢复，SweepTombstones清理其墓碑和元数据，未过期的墓碑保留
+func TestSweepTombstones(t *testing.T) {
+	clock := newTestClock()
+	r, mr := newTestRedis(t, WithClock(clock.now), WithTombstone(time.Hour), WithMetadata())
+	for id, score := range map[string]int64{"old": 10, "recent": 20} {
+		if err := r.UpdateScore(id, score); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetMetadata(id, PlayerMeta{"name": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := r.RemovePlayer("old"); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(50 * time.Minute)
+	if _, err := r.RemovePlayer("recent"); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(20 * time.Minute)
+
+	if restored, err := r.RestorePlayer("old"); err != nil || restored {
+		t.Errorf("RestorePlayer(old) after grace = %v, %v, want false", restored, err)
+	}
+	if purged, err := r.SweepTombstones(); err != nil || purged != 1 {
+		t.Fatalf("SweepTombstones = %d, %v, want 1", purged, err)
+	}
+	for _, key := range []string{r.tombstoneKey(), r.tombstoneExpireKey()} {
+		if members, err := mr.ZMembers(key); err != nil || !equalIDs(members, []string{"recent"}) {
+			t.Errorf("%s members = %v, %v, want [recent]", key, members, err)
+		}
+	}
+	if mr.HGet(r.metaKey(), "old") != "" {
+		t.Error("metadata of the purged player still exists")
+	}
+
+	if restored, err := r.RestorePlayer("recent"); err != nil || !restored {
+		t.Errorf("RestorePlayer(recent) = %v, %v, want true", restored, err)
+	}
+	if rank, score, err := r.GetRank("recent"); err != nil || rank != 1 || score != 20 {
+		t.Errorf("GetRank(recent) = %d, %d, %v, want 1, 20", rank, score, err)
+	}
+}
+
+// TestOnRankChangeConcurrent 写入期间注册和取消名次变化回调，配合-race检查没有数据竞争
+func TestOnRankChangeConcurrent(t *testing.T) {
+	r, _ := newTestRedis(t)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if i%2 == 0 {
+				r.OnRankChange(func(string, int, int) {})
+			} else {
+				r.OnRankChange(nil)
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := r.UpdateScore(fmt.Sprintf("p%d", i%5), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+// TestBottomAndScoreRangeMetadata GetBottomN和GetPlayersInScoreRange返回的玩家同样带有元数据，两种排行榜一致
+func TestBottomAndScoreRangeMetadata(t *testing.T) {
+	type metaRanker interface {
+		Ranker
+		SetMetadata(playerID string, meta PlayerMeta) error
+		GetBottomN(n int) ([]PlayerRank, error)
+		GetPlayersInScoreRange(minScore, maxScore int64, limit int) ([]PlayerRank, error)
+	}
+	rds, _ := newTestRedis(t, WithMetadata())
+	for _, r := range []metaRanker{NewRankingSystem(), rds} {
+		for id, score := range map[string]int64{"a": 30, "b": 20, "c": 10} {
+			if err := r.UpdateScore(id, score); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.SetMetadata(id, PlayerMeta{"name": "name-" + id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		bottom, err := r.GetBottomN(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inRange, err := r.GetPlayersInScoreRange(15, 30, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, got := range map[string][]PlayerRank{"GetBottomN": bottom, "GetPlayersInScoreRange": inRange} {
+			if len(got) != 2 {
+				t.Fatalf("%T %s = %+v", r, name, got)
+			}
+			for _, pr := range got {
+				if pr.Meta["name"] != "name-"+pr.PlayerID {
+					t.Errorf("%T %s %s Meta = %v", r, name, pr.PlayerID, pr.Meta)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package game_rank_test
+
+import "time"
+
+const (
+	// DefaultRetries WithRetry未指定次数时的默认重试次数
+	DefaultRetries = 3
+	// DefaultRetryBackoff WithRetry未指定退避时长时首次重试前的默认等待时长
+	DefaultRetryBackoff = 50 * time.Millisecond
+)
+
+// WithRetry Redis排行榜遇到断线、超时、服务端加载中等暂时性错误时自动重试，最多重试maxRetries次
+// 第i次重试前等待baseBackoff*2^(i-1)；玩家不存在、参数无效等逻辑错误不重试
+// maxRetries<=0时使用DefaultRetries，baseBackoff<=0时使用DefaultRetryBackoff
+// 上下文取消或剩余时间不足以等待下一次重试时立即返回最后一次的错误
+// 只重试幂等的操作：UpdateScore、UpdateScoreWithTieBreak、UpdateScores、GetRank、GetScore、Exists、
+// GetRankDetail、GetTopN、GetTopNApprox、GetRankPage、GetPlayerRankRange、GetTotalPlayers、RemovePlayer、RemovePlayers；IncrementScore等非幂等写入不重试
+func WithRetry(maxRetries int, baseBackoff time.Duration) Option {
+	return func(o *options) {
+		if maxRetries <= 0 {
+			maxRetries = DefaultRetries
+		}
+		if baseBackoff <= 0 {
+			baseBackoff = DefaultRetryBackoff
+		}
+		o.retries = maxRetries
+		o.retryBackoff = baseBackoff
+	}
+}
+
+// retry 执行op，遇到暂时性错误时按配置退避重试，未启用WithRetry时只执行一次
+func (r *RedisRankingList) retry(op func() error) error {
+	err := op()
+	backoff := r.opts.retryBackoff
+	for i := 0; i < r.opts.retries && isTransient(err); i++ {
+		// 上下文的超时本身也是net.Error，先确认调用方仍在等待
+		if r.ctx.Err() != nil {
+			return err
+		}
+		if deadline, ok := r.ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		err = op()
+	}
+	return err
+}
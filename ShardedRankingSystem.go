@@ -0,0 +1,210 @@
+package game_rank_test
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"time"
+)
+
+// ShardedRankingSystem 按玩家ID哈希分片的内存排行榜，用于高并发写入
+// 每个分片是独立加锁的RankingSystem，不同分片的UpdateScore互不阻塞；
+// 全榜查询逐个分片读取后合并，代价随分片数增加，且各分片不是在同一时刻读取的，并发写入时结果可能有瞬时的不一致
+// WithMaxSize对每个分片分别生效，全榜前n名总会保留，总人数最多为分片数×n；不支持OnRankChange
+// WithRankMode(DenseRanking)同样生效，名次与单个RankingSystem一致，同一分数出现在多个分片时只计一次
+type ShardedRankingSystem struct {
+	shards []*RankingSystem
+	opts   options
+}
+
+// NewShardedRankingSystem 创建分为shards个分片的排行榜，shards<=0时按GOMAXPROCS取值，opts对所有分片生效
+func NewShardedRankingSystem(shards int, opts ...Option) *ShardedRankingSystem {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedRankingSystem{
+		shards: make([]*RankingSystem, shards),
+		opts:   newOptions(opts),
+	}
+	// 监控只由ShardedRankingSystem上报，分片内部的调用不再重复上报
+	shardOpts := append(opts[:len(opts):len(opts)], WithMetricsObserver(nil))
+	for i := range s.shards {
+		s.shards[i] = NewRankingSystem(shardOpts...)
+	}
+	return s
+}
+
+// shard 玩家所在的分片
+func (s *ShardedRankingSystem) shard(playerID string) *RankingSystem {
+	h := fnv.New32a()
+	h.Write([]byte(playerID))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// UpdateScore 更新玩家积分，只锁玩家所在的分片
+func (s *ShardedRankingSystem) UpdateScore(playerID string, score int64) (err error) {
+	defer s.opts.observeUpdate("UpdateScore", time.Now(), &err)
+	return s.shard(playerID).UpdateScore(playerID, score)
+}
+
+// GetScore 查询玩家当前分数，玩家不存在时返回ErrPlayerNotFound
+func (s *ShardedRankingSystem) GetScore(playerID string) (score int64, err error) {
+	defer s.opts.observeQuery("GetScore", time.Now(), &err)
+	return s.shard(playerID).GetScore(playerID)
+}
+
+// Exists 玩家是否在榜
+func (s *ShardedRankingSystem) Exists(playerID string) (present bool, err error) {
+	defer s.opts.observeQuery("Exists", time.Now(), &err)
+	return s.shard(playerID).Exists(playerID)
+}
+
+// RemovePlayer 移除玩家，wasPresent表示调用前玩家是否在榜
+func (s *ShardedRankingSystem) RemovePlayer(playerID string) (wasPresent bool, err error) {
+	defer s.opts.observeUpdate("RemovePlayer", time.Now(), &err)
+	return s.shard(playerID).RemovePlayer(playerID)
+}
+
+// GetTotalPlayers 各分片人数之和
+func (s *ShardedRankingSystem) GetTotalPlayers() (total int64, err error) {
+	defer s.opts.observeQuery("GetTotalPlayers", time.Now(), &err)
+	for _, sh := range s.shards {
+		n, _ := sh.GetTotalPlayers()
+		total += n
+	}
+	return total, nil
+}
+
+// rankAbove 名次排在内部分数score之前的名次数：默认为各分片中分数更高的玩家数之和，
+// DenseRanking时为各分片更高分数的并集大小，同一分数可能出现在多个分片中，不能直接相加
+func (s *ShardedRankingSystem) rankAbove(score int64) int {
+	if s.opts.rankMode != DenseRanking {
+		above := 0
+		for _, sh := range s.shards {
+			sh.mu.RLock()
+			above += sh.ranks.countAbove(score)
+			sh.mu.RUnlock()
+		}
+		return above
+	}
+
+	distinct := make(map[int64]struct{})
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, p := range sh.ranks.distinct.page(0, sh.ranks.countDistinctAbove(score)) {
+			distinct[p.Score] = struct{}{}
+		}
+		sh.mu.RUnlock()
+	}
+	return len(distinct)
+}
+
+// GetRank 查询玩家的全榜名次和分数，逐个分片统计排在前面的名次数
+// DenseRanking时需要取出各分片更高的不同分数去重，代价随更高分数的个数线性增加
+func (s *ShardedRankingSystem) GetRank(playerID string) (rank int, score int64, err error) {
+	defer s.opts.observeQuery("GetRank", time.Now(), &err)
+	score, err = s.shard(playerID).GetScore(playerID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.opts.outRank(s.rankAbove(s.opts.orient(score)) + 1), score, nil
+}
+
+// GetTopN 获取全榜前N名，从每个分片取前N名后合并
+func (s *ShardedRankingSystem) GetTopN(n int) (rankings []PlayerRank, err error) {
+	defer s.opts.observeQuery("GetTopN", time.Now(), &err)
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+	return s.page(0, n), nil
+}
+
+// GetRankPage 获取全榜从offset（从0开始）起的limit名玩家，名次按全榜并列规则计算
+// 每个分片都要取前offset+limit名再合并，深分页的代价随offset线性增加
+func (s *ShardedRankingSystem) GetRankPage(offset, limit int) (rankings []PlayerRank, err error) {
+	defer s.opts.observeQuery("GetRankPage", time.Now(), &err)
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative", ErrInvalidRange)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be greater than 0", ErrInvalidRange)
+	}
+	return s.page(offset, limit), nil
+}
+
+// GetPlayerRankRange 查询自己名次前后共N名玩家（包括自己），窗口规则同RankingSystem.GetPlayerRankRange
+func (s *ShardedRankingSystem) GetPlayerRankRange(playerID string, n int) (rankings []PlayerRank, err error) {
+	defer s.opts.observeQuery("GetPlayerRankRange", time.Now(), &err)
+	if n <= 0 {
+		n = s.opts.defaultWindow
+	}
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	sh := s.shard(playerID)
+	sh.mu.RLock()
+	p, exists := sh.players[playerID]
+	var player Player
+	if exists {
+		player = *p
+	}
+	sh.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerNotFound, playerID)
+	}
+
+	// 玩家的全榜下标为各分片中排在其前面的人数之和
+	index, total := 0, 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		index += sh.ranks.countBefore(&player)
+		total += sh.ranks.len()
+		sh.mu.RUnlock()
+	}
+
+	start, end := rankWindow(index, total, n)
+	result := s.page(start, end-start)
+	for i := range result {
+		result[i].Position = i + 1
+	}
+	return result, nil
+}
+
+// page 合并各分片的前offset+limit名，返回全榜下标[offset, offset+limit)的玩家
+func (s *ShardedRankingSystem) page(offset, limit int) []PlayerRank {
+	var merged []*Player
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, p := range sh.ranks.page(0, offset+limit) {
+			cp := *p
+			merged = append(merged, &cp)
+		}
+		sh.mu.RUnlock()
+	}
+	sortPlayersBy(merged, s.opts.rankBefore())
+
+	if offset >= len(merged) {
+		return []PlayerRank{}
+	}
+	players := merged[offset:min(len(merged), offset+limit)]
+
+	// 页首可能延续上一页的并列，名次为更高分人数+1，DenseRanking时为更高的不同分数个数+1
+	// 全榜排在页首之前的玩家都在merged中，不需要再逐个分片统计
+	rank := 1
+	for i := 0; i < offset && merged[i].Score != players[0].Score; i++ {
+		if s.opts.rankMode != DenseRanking {
+			rank++
+		} else if i == 0 || merged[i].Score != merged[i-1].Score {
+			rank++
+		}
+	}
+	result := make([]PlayerRank, 0, len(players))
+	for i, p := range players {
+		if i > 0 && p.Score != players[i-1].Score {
+			rank = s.opts.nextRank(rank, offset+i+1)
+		}
+		result = append(result, s.opts.playerRank(p, rank))
+	}
+	return result
+}
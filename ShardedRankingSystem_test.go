@@ -0,0 +1,92 @@
+package game_rank_test
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestShardedRankModes 同分玩家分散在不同分片时，分片排行榜的名次与单个RankingSystem一致，标准和密集排名都覆盖
+func TestShardedRankModes(t *testing.T) {
+	for _, mode := range []RankMode{StandardRanking, DenseRanking} {
+		clock := newTestClock()
+		sharded := NewShardedRankingSystem(4, WithClock(clock.now), WithRankMode(mode))
+		single := NewRankingSystem(WithClock(clock.now), WithRankMode(mode))
+		for i := 0; i < 60; i++ {
+			clock.advance(time.Second)
+			id, score := "p"+strconv.Itoa(i), int64(i%7*10)
+			for _, r := range []Ranker{sharded, single} {
+				if err := r.UpdateScore(id, score); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for i := 0; i < 60; i++ {
+			id := "p" + strconv.Itoa(i)
+			gotRank, gotScore, err := sharded.GetRank(id)
+			wantRank, wantScore, _ := single.GetRank(id)
+			if err != nil || gotRank != wantRank || gotScore != wantScore {
+				t.Errorf("mode=%v GetRank(%s) = %d, %d, %v, want %d, %d", mode, id, gotRank, gotScore, err, wantRank, wantScore)
+			}
+		}
+		for _, offset := range []int{0, 5, 8, 9, 17, 50} {
+			got, err := sharded.GetRankPage(offset, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := single.GetRankPage(offset, 10)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("mode=%v GetRankPage(%d, 10) = %+v, want %+v", mode, offset, got, want)
+			}
+		}
+		got, err := sharded.GetPlayerRankRange("p30", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := single.GetPlayerRankRange("p30", 7)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("mode=%v GetPlayerRankRange(p30, 7) = %+v, want %+v", mode, got, want)
+		}
+	}
+}
+
+// BenchmarkShardedRankingSystem 16个写入goroutine并发UpdateScore时，分片排行榜与单个RankingSystem的吞吐
+// 单核机器上两者接近，分片的收益来自多核下不同分片的写入互不阻塞
+func BenchmarkShardedRankingSystem(b *testing.B) {
+	const players, writers = 100000, 16
+	ids := make([]string, players)
+	for i := range ids {
+		ids[i] = "p" + strconv.Itoa(i)
+	}
+
+	boards := []struct {
+		name string
+		r    Ranker
+	}{
+		{"ShardedRankingSystem", NewShardedRankingSystem(16)},
+		{"RankingSystem", NewRankingSystem()},
+	}
+	for _, board := range boards {
+		b.Run(board.name, func(b *testing.B) {
+			var seq int64
+			// RunParallel启动parallelism×GOMAXPROCS个goroutine，按核数折算成至少16个写入者
+			procs := runtime.GOMAXPROCS(0)
+			b.SetParallelism((writers + procs - 1) / procs)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&seq, 1)
+					if err := board.r.UpdateScore(ids[i%players], i); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package game_rank_test
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+)
+
+// TopKRankingList 只保留前K名的内存排行榜
+// 用容量为K的最小堆维护前K名，UpdateScore为O(log K)，内存占用固定
+// 代价是只能查询前K名和提交次数的近似值，不能查询K名以外任意玩家的名次，也不能得到准确的总人数；
+// 已在前K名的玩家分数下降时仍留在堆中，被挤出的玩家不会因此回到榜内
+type TopKRankingList struct {
+	k       int
+	entries *topKHeap
+	index   map[string]*topKEntry // 堆内玩家
+	rest    int64                 // 未进入或被挤出前K名的提交次数
+	opts    options
+	mu      sync.RWMutex
+}
+
+// topKEntry 堆中的玩家及其在堆中的下标
+type topKEntry struct {
+	player Player
+	pos    int
+}
+
+// topKHeap 最小堆，堆顶是前K名中排名最靠后的玩家
+type topKHeap struct {
+	entries []*topKEntry
+	before  func(a, b *Player) bool // 排名比较规则
+}
+
+// newTopKHeap 创建容量为k、按before比较的堆
+func newTopKHeap(k int, before func(a, b *Player) bool) *topKHeap {
+	return &topKHeap{entries: make([]*topKEntry, 0, k), before: before}
+}
+
+func (h *topKHeap) Len() int { return len(h.entries) }
+
+func (h *topKHeap) Less(i, j int) bool {
+	return h.before(&h.entries[j].player, &h.entries[i].player)
+}
+
+func (h *topKHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].pos = i
+	h.entries[j].pos = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.pos = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := h.entries
+	e := old[len(old)-1]
+	h.entries = old[:len(old)-1]
+	return e
+}
+
+// better a是否排在b前面
+func (h *topKHeap) better(a, b *topKEntry) bool {
+	return h.before(&a.player, &b.player)
+}
+
+// replaceTop 用e替换堆顶并调整堆
+func (h *topKHeap) replaceTop(e *topKEntry) {
+	e.pos = 0
+	h.entries[0] = e
+	heap.Fix(h, 0)
+}
+
+// NewTopKRankingList 创建一个只保留前k名的排行榜，k必须大于0
+// 支持WithClock、WithOrder、WithTieBreak、WithTiePolicy、WithScoreQuantizer、WithRankMode和WithZeroBasedRanks，其余选项不生效
+func NewTopKRankingList(k int, opts ...Option) (*TopKRankingList, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k=%d", ErrInvalidN, k)
+	}
+
+	o := newOptions(opts)
+	return &TopKRankingList{
+		k:       k,
+		entries: newTopKHeap(k, o.rankBefore()),
+		index:   make(map[string]*topKEntry, k),
+		opts:    o,
+	}, nil
+}
+
+// UpdateScore 更新玩家积分，未能进入前K名的提交只计入ApproxSubmissions
+// 与Ranker的UpdateScore签名一致，目前总是返回nil
+func (t *TopKRankingList) UpdateScore(playerID string, score int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	score = t.opts.orient(t.opts.quantize(score))
+	now := t.opts.now()
+	if e, exists := t.index[playerID]; exists {
+		// 与RankingSystem一致，默认只有分数变化时才更新时间戳
+		if e.player.Score != score || t.opts.tiePolicy == RefreshOnResubmit {
+			e.player.Score = score
+			e.player.UpdateTime = now
+			heap.Fix(t.entries, e.pos)
+		}
+		return nil
+	}
+
+	e := &topKEntry{player: Player{ID: playerID, Score: score, UpdateTime: now}}
+	if t.entries.Len() < t.k {
+		heap.Push(t.entries, e)
+		t.index[playerID] = e
+		return nil
+	}
+
+	// 堆已满，只有比堆顶更好的玩家才能替换堆顶
+	if !t.entries.better(e, t.entries.entries[0]) {
+		t.rest++
+		return nil
+	}
+	delete(t.index, t.entries.entries[0].player.ID)
+	t.entries.replaceTop(e)
+	t.index[playerID] = e
+	t.rest++
+	return nil
+}
+
+// GetTopN 获取前N名玩家的分数和名次，N最多为K
+func (t *TopKRankingList) GetTopN(n int) ([]PlayerRank, error) {
+	if n <= 0 {
+		return nil, ErrInvalidN
+	}
+
+	t.mu.RLock()
+	players := make([]*Player, 0, t.entries.Len())
+	for _, e := range t.entries.entries {
+		p := e.player
+		players = append(players, &p)
+	}
+	t.mu.RUnlock()
+
+	sortPlayersBy(players, t.opts.rankBefore())
+
+	ranks := t.opts.sortedRanks(players)
+	result := make([]PlayerRank, 0, min(n, len(players)))
+	for i := 0; i < len(players) && i < n; i++ {
+		result = append(result, t.opts.playerRank(players[i], ranks[i]))
+	}
+
+	return result, nil
+}
+
+// ApproxSubmissions 前K名的人数加上K名以外的提交次数，只能作为总人数的上界
+// 不保存K名以外的玩家，同一玩家在K名以外多次提交、被挤出的玩家再次提交都会重复计数
+func (t *TopKRankingList) ApproxSubmissions() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return int64(t.entries.Len()) + t.rest
+}
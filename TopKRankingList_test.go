@@ -0,0 +1,142 @@
+package game_rank_test
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestTopKRankingListManyUpdates 大量更新后前K名与完整排行榜的前K名一致
+// 分数只升不降，此时被挤出前K名的玩家不会因降分留在堆中，两者应完全相同
+func TestTopKRankingListManyUpdates(t *testing.T) {
+	const k, players, updates = 50, 2000, 100000
+
+	clock := newTestClock()
+	for _, opts := range [][]Option{nil, {WithTieBreak(LatestFirst)}, {WithOrder(Ascending)}, {WithRankMode(DenseRanking)}} {
+		opts = append([]Option{WithClock(clock.now)}, opts...)
+		topK, err := NewTopKRankingList(k, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full := NewRankingSystem(opts...)
+		o := newOptions(opts)
+
+		rng := rand.New(rand.NewSource(1))
+		best := make(map[string]int64)
+		for i := 0; i < updates; i++ {
+			clock.advance(time.Second)
+			id := "p" + strconv.Itoa(rng.Intn(players))
+			// 升序榜单上成绩变好意味着分数变低
+			best[id] += o.orient(int64(rng.Intn(10) + 1))
+			if err := topK.UpdateScore(id, best[id]); err != nil {
+				t.Fatal(err)
+			}
+			if err := full.UpdateScore(id, best[id]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := topK.GetTopN(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := full.GetTopN(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("top %d differs\ngot  %v\nwant %v", k, got, want)
+		}
+		if total := topK.ApproxSubmissions(); total < int64(len(best)) {
+			t.Errorf("ApproxSubmissions() = %d, want an upper bound of %d", total, len(best))
+		}
+	}
+}
+
+// TestTopKRankingListTies 同分时先达到的排前面，名次并列
+func TestTopKRankingListTies(t *testing.T) {
+	clock := newTestClock()
+	start := clock.now()
+	topK, err := NewTopKRankingList(2, WithClock(clock.now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// c同分但更晚，挤不进前2名
+	for i, id := range []string{"a", "b", "c"} {
+		if i > 0 {
+			clock.advance(time.Second)
+		}
+		if err := topK.UpdateScore(id, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := topK.GetTopN(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].PlayerID != "a" || got[1].PlayerID != "b" || got[0].Rank != 1 || got[1].Rank != 1 {
+		t.Fatalf("GetTopN = %+v, want a and b tied at rank 1", got)
+	}
+	if !got[1].UpdateTime.Equal(start.Add(time.Second)) {
+		t.Errorf("UpdateTime = %v, want the injected clock", got[1].UpdateTime)
+	}
+}
+
+// TestTopKApproxSubmissions K名以外的每次提交都计数，同一玩家重复提交时结果大于实际人数
+func TestTopKApproxSubmissions(t *testing.T) {
+	topK, err := NewTopKRankingList(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []struct {
+		id    string
+		score int64
+	}{{"a", 30}, {"b", 20}, {"c", 10}, {"c", 5}, {"c", 1}} {
+		if err := topK.UpdateScore(s.id, s.score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := topK.ApproxSubmissions(); got != 5 {
+		t.Errorf("ApproxSubmissions() = %d, want 5 for 3 players", got)
+	}
+}
+
+// TestNewTopKRankingListInvalidK k不大于0时返回ErrInvalidN而不是panic
+func TestNewTopKRankingListInvalidK(t *testing.T) {
+	for _, k := range []int{0, -1} {
+		if _, err := NewTopKRankingList(k); !errors.Is(err, ErrInvalidN) {
+			t.Errorf("NewTopKRankingList(%d) error = %v, want ErrInvalidN", k, err)
+		}
+	}
+}
+
+// BenchmarkTopKUpdateScore 百万名不同玩家写入时，前K名堆与完整排行榜的UpdateScore开销
+func BenchmarkTopKUpdateScore(b *testing.B) {
+	const players = 1000000
+	ids := make([]string, players)
+	for i := range ids {
+		ids[i] = "p" + strconv.Itoa(i)
+	}
+
+	b.Run("TopKRankingList", func(b *testing.B) {
+		topK, err := NewTopKRankingList(100)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = topK.UpdateScore(ids[i%players], int64(i))
+		}
+	})
+	b.Run("RankingSystem", func(b *testing.B) {
+		full := NewRankingSystem()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = full.UpdateScore(ids[i%players], int64(i))
+		}
+	})
+}
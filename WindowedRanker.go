@@ -0,0 +1,197 @@
+package game_rank_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Window 按时间自动分榜的周期
+type Window int
+
+const (
+	// DailyWindow 每个自然日一个排行榜，窗口ID形如2024-05-21
+	DailyWindow Window = iota
+	// WeeklyWindow 每个ISO周一个排行榜，周一开始，窗口ID形如2024-W21
+	WeeklyWindow
+)
+
+// WindowedRanker 按自然日或自然周自动分榜的Redis排行榜，不需要手动轮换赛季
+// 写入和查询都路由到当前窗口的键baseKey+":"+窗口ID，当前窗口按WithClock注入的时钟判断，跨过零点或周一时自动切换；
+// 日期按时钟返回时间所在的时区计算，需要按其他时区分榜时让时钟返回该时区的时间
+// 用过的窗口登记在键baseKey+":windows"对应的集合中，可用Aggregate合并出总榜，用DeleteBefore删除过期窗口
+type WindowedRanker struct {
+	client  *redis.Client
+	baseKey string
+	window  Window
+	opts    []Option
+	now     func() time.Time
+
+	mu        sync.Mutex
+	currentID string
+	current   *RedisRankingList
+}
+
+// NewWindowedRanker 使用调用方的客户端创建按window分榜的排行榜，opts对所有窗口和合并出的总榜生效
+func NewWindowedRanker(client *redis.Client, baseKey string, window Window, opts ...Option) (*WindowedRanker, error) {
+	if window != DailyWindow && window != WeeklyWindow {
+		return nil, fmt.Errorf("分榜周期无效: %d", window)
+	}
+	// 提前校验选项，之后创建各窗口的排行榜不会再失败
+	if _, err := NewRedisRankingWithClient(client, baseKey, opts...); err != nil {
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	return &WindowedRanker{
+		client:  client,
+		baseKey: baseKey,
+		window:  window,
+		opts:    opts,
+		now:     o.now,
+	}, nil
+}
+
+// WindowID 时间t所在窗口的ID，按天为2006-01-02，按周为ISO年和周数如2024-W21，同一周期内的ID按时间先后字典序递增
+func (w *WindowedRanker) WindowID(t time.Time) string {
+	if w.window == WeeklyWindow {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	}
+	return t.Format("2006-01-02")
+}
+
+// windowsKey 登记已用窗口ID的集合
+func (w *WindowedRanker) windowsKey() string {
+	return w.baseKey + ":windows"
+}
+
+// windowKey 窗口windowID的排行榜键
+func (w *WindowedRanker) windowKey(windowID string) string {
+	return w.baseKey + ":" + windowID
+}
+
+// boardAt 键key上使用相同选项的排行榜，选项已在构造时校验过
+func (w *WindowedRanker) boardAt(key string) *RedisRankingList {
+	board, _ := NewRedisRankingWithClient(w.client, key, w.opts...)
+	return board
+}
+
+// Board 窗口windowID对应的排行榜，用于查询历史窗口
+func (w *WindowedRanker) Board(windowID string) *RedisRankingList {
+	return w.boardAt(w.windowKey(windowID))
+}
+
+// Current 当前窗口的排行榜，窗口切换后首次调用时登记新窗口
+func (w *WindowedRanker) Current() (*RedisRankingList, error) {
+	id := w.WindowID(w.now())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.current != nil && w.currentID == id {
+		return w.current, nil
+	}
+	if err := w.client.SAdd(context.Background(), w.windowsKey(), id).Err(); err != nil {
+		return nil, fmt.Errorf("登记窗口失败: %w", err)
+	}
+	w.currentID = id
+	w.current = w.Board(id)
+	return w.current, nil
+}
+
+// UpdateScore 更新玩家在当前窗口的积分
+func (w *WindowedRanker) UpdateScore(playerID string, score int64) error {
+	board, err := w.Current()
+	if err != nil {
+		return err
+	}
+	return board.UpdateScore(playerID, score)
+}
+
+// IncrementScore 在玩家当前窗口的分数上增加delta，返回新分数，玩家在新窗口中从0开始
+func (w *WindowedRanker) IncrementScore(playerID string, delta int64) (int64, error) {
+	board, err := w.Current()
+	if err != nil {
+		return 0, err
+	}
+	return board.IncrementScore(playerID, delta)
+}
+
+// GetRank 查询玩家在当前窗口的名次和分数
+func (w *WindowedRanker) GetRank(playerID string) (int, int64, error) {
+	board, err := w.Current()
+	if err != nil {
+		return 0, 0, err
+	}
+	return board.GetRank(playerID)
+}
+
+// GetTopN 获取当前窗口的前N名
+func (w *WindowedRanker) GetTopN(n int) ([]PlayerRank, error) {
+	board, err := w.Current()
+	if err != nil {
+		return nil, err
+	}
+	return board.GetTopN(n)
+}
+
+// Windows 按时间先后返回所有已登记的窗口ID，包括其他进程登记的
+func (w *WindowedRanker) Windows() ([]string, error) {
+	ids, err := w.client.SMembers(context.Background(), w.windowsKey()).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取窗口列表失败: %w", err)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Aggregate 把windowIDs各窗口中同一玩家的分数相加写入dstKey，返回dstKey上的排行榜，用于总榜或月榜
+// dstKey原有内容被替换；同分先后取各窗口中最靠前的一次，默认即最早达到的时间；任一玩家的和超出范围时返回ErrScoreOutOfRange
+// 总榜是合并时刻的快照，之后的写入不会自动计入，需要时重新合并；常用w.Windows()的结果作为windowIDs得到全部时间的总榜
+func (w *WindowedRanker) Aggregate(dstKey string, windowIDs ...string) (*RedisRankingList, error) {
+	dst := w.boardAt(dstKey)
+	srcKeys := make([]string, len(windowIDs))
+	for i, id := range windowIDs {
+		srcKeys[i] = w.windowKey(id)
+	}
+	if err := dst.sumInto(dstKey, srcKeys); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// DeleteWindow 删除窗口windowID的排行榜及其墓碑和元数据，并取消登记
+func (w *WindowedRanker) DeleteWindow(windowID string) error {
+	if err := w.Board(windowID).Clear(); err != nil {
+		return err
+	}
+	if err := w.client.SRem(context.Background(), w.windowsKey(), windowID).Err(); err != nil {
+		return fmt.Errorf("注销窗口失败: %w", err)
+	}
+	return nil
+}
+
+// DeleteBefore 删除时间t所在窗口之前的所有已登记窗口，返回删除的窗口数，用于定期清理过期数据
+func (w *WindowedRanker) DeleteBefore(t time.Time) (deleted int, err error) {
+	ids, err := w.Windows()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := w.WindowID(t)
+	for _, id := range ids {
+		if id >= cutoff {
+			break
+		}
+		if err := w.DeleteWindow(id); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
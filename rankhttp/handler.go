@@ -0,0 +1,184 @@
+// Package rankhttp 把排行榜包装为返回JSON的http.Handler，供网页前端直接调用
+package rankhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	rank "github.com/remisnow/game_rank_test.git"
+)
+
+// DefaultMaxN GET /top未指定上限时允许的最大n
+const DefaultMaxN = 100
+
+// Handler 把Ranker包装为http.Handler，路由如下：
+//
+//	GET    /rank/{id}   查询玩家名次和分数
+//	GET    /top?n=10    查询前n名
+//	POST   /score       更新积分，请求体为{"id":"p1","score":100}
+//	DELETE /player/{id} 移除玩家
+//
+// 路径按挂载点解析，挂载到子路径时用http.StripPrefix去掉前缀；
+// 玩家不存在返回404，n无效或请求体无效返回400，错误响应为{"error":"..."}
+type Handler struct {
+	ranker rank.Ranker
+	maxN   int
+}
+
+// NewHandler 创建包装ranker的Handler，maxN<=0时使用DefaultMaxN
+func NewHandler(ranker rank.Ranker, maxN int) *Handler {
+	if maxN <= 0 {
+		maxN = DefaultMaxN
+	}
+	return &Handler{ranker: ranker, maxN: maxN}
+}
+
+// playerRank 名次查询和前n名的响应
+type playerRank struct {
+	ID    string `json:"id"`
+	Rank  int    `json:"rank"`
+	Score int64  `json:"score"`
+}
+
+// scoreRequest POST /score的请求体
+type scoreRequest struct {
+	ID    string `json:"id"`
+	Score int64  `json:"score"`
+}
+
+// ServeHTTP 按路径和方法分发请求
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/rank/"):
+		if allow(w, req, http.MethodGet) {
+			h.getRank(w, strings.TrimPrefix(path, "/rank/"))
+		}
+	case path == "/top":
+		if allow(w, req, http.MethodGet) {
+			h.getTop(w, req)
+		}
+	case path == "/score":
+		if allow(w, req, http.MethodPost) {
+			h.postScore(w, req)
+		}
+	case strings.HasPrefix(path, "/player/"):
+		if allow(w, req, http.MethodDelete) {
+			h.deletePlayer(w, strings.TrimPrefix(path, "/player/"))
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// getRank GET /rank/{id}
+func (h *Handler) getRank(w http.ResponseWriter, id string) {
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing player id")
+		return
+	}
+
+	r, score, err := h.ranker.GetRank(id)
+	if err != nil {
+		writeRankerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, playerRank{ID: id, Rank: r, Score: score})
+}
+
+// getTop GET /top?n=
+func (h *Handler) getTop(w http.ResponseWriter, req *http.Request) {
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || n <= 0 || n > h.maxN {
+		writeError(w, http.StatusBadRequest, "n must be between 1 and "+strconv.Itoa(h.maxN))
+		return
+	}
+
+	top, err := h.ranker.GetTopN(n)
+	if err != nil {
+		writeRankerError(w, err)
+		return
+	}
+	resp := make([]playerRank, len(top))
+	for i, pr := range top {
+		resp[i] = playerRank{ID: pr.PlayerID, Rank: pr.Rank, Score: pr.Score}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// postScore POST /score
+func (h *Handler) postScore(w http.ResponseWriter, req *http.Request) {
+	var body scoreRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if body.ID == "" {
+		writeError(w, http.StatusBadRequest, "missing player id")
+		return
+	}
+
+	if err := h.ranker.UpdateScore(body.ID, body.Score); err != nil {
+		writeRankerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deletePlayer DELETE /player/{id}，玩家原本不在榜时返回404
+func (h *Handler) deletePlayer(w http.ResponseWriter, id string) {
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing player id")
+		return
+	}
+
+	wasPresent, err := h.ranker.RemovePlayer(id)
+	if err != nil {
+		writeRankerError(w, err)
+		return
+	}
+	if !wasPresent {
+		writeError(w, http.StatusNotFound, rank.ErrPlayerNotFound.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allow 请求方法是否为method，不是时返回405
+func allow(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// writeRankerError 把排行榜的错误转换为状态码，未知错误按500处理且不向客户端暴露细节
+func writeRankerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rank.ErrPlayerNotFound):
+		writeError(w, http.StatusNotFound, rank.ErrPlayerNotFound.Error())
+	case errors.Is(err, rank.ErrInvalidN), errors.Is(err, rank.ErrInvalidRange), errors.Is(err, rank.ErrScoreOutOfRange):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// writeError 写出{"error":msg}
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+// writeJSON 以status写出v的JSON编码
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
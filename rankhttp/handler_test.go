@@ -0,0 +1,173 @@
+package rankhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	rank "github.com/remisnow/game_rank_test.git"
+)
+
+// errRanker 所有调用都返回err的Ranker，用于检查错误到状态码的转换
+type errRanker struct {
+	rank.Ranker
+	err error
+}
+
+func (r errRanker) GetRank(string) (int, int64, error)     { return 0, 0, r.err }
+func (r errRanker) GetTopN(int) ([]rank.PlayerRank, error) { return nil, r.err }
+func (r errRanker) UpdateScore(string, int64) error        { return r.err }
+func (r errRanker) RemovePlayer(string) (bool, error)      { return false, r.err }
+
+// do 向h发送请求，返回响应
+func do(t *testing.T, h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// decode 解析响应体，状态码不是want时失败
+func decode(t *testing.T, w *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if w.Code != want {
+		t.Fatalf("status = %d, want %d, body %s", w.Code, want, w.Body)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body, err)
+	}
+}
+
+// TestHandlerRoutes 每个路由在内存排行榜上的正常响应
+func TestHandlerRoutes(t *testing.T) {
+	h := NewHandler(rank.NewRankingSystem(), 0)
+
+	for _, body := range []string{`{"id":"p1","score":100}`, `{"id":"p2","score":200}`, `{"id":"p3","score":50}`} {
+		decode(t, do(t, h, http.MethodPost, "/score", body), http.StatusNoContent, nil)
+	}
+
+	var pr playerRank
+	decode(t, do(t, h, http.MethodGet, "/rank/p1", ""), http.StatusOK, &pr)
+	if want := (playerRank{ID: "p1", Rank: 2, Score: 100}); pr != want {
+		t.Fatalf("GET /rank/p1 = %+v, want %+v", pr, want)
+	}
+
+	var top []playerRank
+	decode(t, do(t, h, http.MethodGet, "/top?n=2", ""), http.StatusOK, &top)
+	want := []playerRank{{ID: "p2", Rank: 1, Score: 200}, {ID: "p1", Rank: 2, Score: 100}}
+	if len(top) != len(want) || top[0] != want[0] || top[1] != want[1] {
+		t.Fatalf("GET /top?n=2 = %+v, want %+v", top, want)
+	}
+
+	decode(t, do(t, h, http.MethodDelete, "/player/p2", ""), http.StatusNoContent, nil)
+	decode(t, do(t, h, http.MethodGet, "/rank/p2", ""), http.StatusNotFound, nil)
+	decode(t, do(t, h, http.MethodGet, "/rank/p1", ""), http.StatusOK, &pr)
+	if pr.Rank != 1 {
+		t.Fatalf("rank after removal = %d, want 1", pr.Rank)
+	}
+}
+
+// TestHandlerStatusCodes 玩家不存在返回404，n无效、请求体无效返回400
+func TestHandlerStatusCodes(t *testing.T) {
+	h := NewHandler(rank.NewRankingSystem(), 5)
+	decode(t, do(t, h, http.MethodPost, "/score", `{"id":"p1","score":1}`), http.StatusNoContent, nil)
+
+	cases := []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"unknown player", http.MethodGet, "/rank/nobody", "", http.StatusNotFound},
+		{"missing rank id", http.MethodGet, "/rank/", "", http.StatusBadRequest},
+		{"remove unknown player", http.MethodDelete, "/player/nobody", "", http.StatusNotFound},
+		{"missing n", http.MethodGet, "/top", "", http.StatusBadRequest},
+		{"non-numeric n", http.MethodGet, "/top?n=abc", "", http.StatusBadRequest},
+		{"zero n", http.MethodGet, "/top?n=0", "", http.StatusBadRequest},
+		{"n above max", http.MethodGet, "/top?n=6", "", http.StatusBadRequest},
+		{"invalid json", http.MethodPost, "/score", `{"id":`, http.StatusBadRequest},
+		{"wrong score type", http.MethodPost, "/score", `{"id":"p1","score":"high"}`, http.StatusBadRequest},
+		{"missing score id", http.MethodPost, "/score", `{"score":1}`, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/top?n=1", "", http.StatusMethodNotAllowed},
+		{"unknown route", http.MethodGet, "/nowhere", "", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var resp struct {
+				Error string `json:"error"`
+			}
+			decode(t, do(t, h, tc.method, tc.target, tc.body), tc.want, &resp)
+			if resp.Error == "" {
+				t.Fatal("error response has no message")
+			}
+		})
+	}
+}
+
+// TestHandlerRankerErrors 排行榜返回的错误在每个路由上转换为相同的状态码，未知错误返回500且不暴露细节
+func TestHandlerRankerErrors(t *testing.T) {
+	requests := []struct{ method, target, body string }{
+		{http.MethodGet, "/rank/p1", ""},
+		{http.MethodGet, "/top?n=1", ""},
+		{http.MethodPost, "/score", `{"id":"p1","score":1}`},
+		{http.MethodDelete, "/player/p1", ""},
+	}
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: p1", rank.ErrPlayerNotFound), http.StatusNotFound},
+		{rank.ErrInvalidN, http.StatusBadRequest},
+		{fmt.Errorf("%w: offset", rank.ErrInvalidRange), http.StatusBadRequest},
+		{fmt.Errorf("%w: 1", rank.ErrScoreOutOfRange), http.StatusBadRequest},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		h := NewHandler(errRanker{err: tc.err}, 0)
+		for _, r := range requests {
+			var resp struct {
+				Error string `json:"error"`
+			}
+			w := do(t, h, r.method, r.target, r.body)
+			if w.Code != tc.want {
+				t.Fatalf("%s %s with %v: status = %d, want %d", r.method, r.target, tc.err, w.Code, tc.want)
+			}
+			decode(t, w, tc.want, &resp)
+			if tc.want == http.StatusInternalServerError && resp.Error != "internal error" {
+				t.Fatalf("%s %s: 500 body exposes %q", r.method, r.target, resp.Error)
+			}
+		}
+	}
+}
+
+// TestHandlerRedisDown Redis不可用时每个路由都返回500
+func TestHandlerRedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	list, err := rank.NewRedisRankingWithClient(client, "rank:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.Close()
+
+	h := NewHandler(list, 0)
+	for _, r := range []struct{ method, target, body string }{
+		{http.MethodGet, "/rank/p1", ""},
+		{http.MethodGet, "/top?n=1", ""},
+		{http.MethodPost, "/score", `{"id":"p1","score":1}`},
+		{http.MethodDelete, "/player/p1", ""},
+	} {
+		if w := do(t, h, r.method, r.target, r.body); w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s %s: status = %d, want 500, body %s", r.method, r.target, w.Code, w.Body)
+		}
+	}
+}